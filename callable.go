@@ -1,41 +1,108 @@
 package expr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 type Callable interface {
-	Call(arguments []interface{}) interface{}
+	Call(arguments []interface{}) (interface{}, error)
 	ArgNum() int
 }
 
+// VariadicCallable is a Callable accepting a variable number of arguments,
+// e.g. a variadic Go function or a function with optional arguments.
+type VariadicCallable interface {
+	Callable
+	// Arity returns the minimum and the maximum number of arguments. A
+	// negative max means there is no upper limit.
+	Arity() (min, max int)
+}
+
+// ContextCallable is a Callable which receives the context of the
+// evaluation, see Interpreter.InterpretContext.
+type ContextCallable interface {
+	Callable
+	CallContext(ctx context.Context, arguments []interface{}) (interface{}, error)
+}
+
+// arity returns the number of arguments accepted by c.
+func arity(c Callable) (min, max int) {
+	if v, ok := c.(VariadicCallable); ok {
+		return v.Arity()
+	}
+	return c.ArgNum(), c.ArgNum()
+}
+
 type PrintFunc struct{}
 
-func (PrintFunc) Call(_ *Interpreter, args []interface{}) interface{} {
+func (PrintFunc) Call(args []interface{}) (interface{}, error) {
 	fmt.Printf("%+v", args)
-	return nil
+	return nil, nil
 }
 
 func (PrintFunc) ArgNum() int { return 1 }
 
 type GoFunc struct {
-	argNum int
-	call   func(arguments []interface{}) interface{}
+	typ       reflect.Type
+	argNum    int
+	variadic  bool
+	signature string
+	call      func(ctx context.Context, arguments []interface{}) (interface{}, error)
 }
 
-func (f *GoFunc) Call(args []interface{}) interface{} {
-	return f.call(args)
+var (
+	_ VariadicCallable = (*GoFunc)(nil)
+	_ ContextCallable  = (*GoFunc)(nil)
+)
+
+func (f *GoFunc) Call(args []interface{}) (interface{}, error) {
+	return f.call(context.Background(), args)
+}
+
+func (f *GoFunc) CallContext(ctx context.Context, args []interface{}) (interface{}, error) {
+	return f.call(ctx, args)
 }
 
+// ArgNum returns the number of arguments, not counting the variadic ones.
 func (f *GoFunc) ArgNum() int {
 	return f.argNum
 }
 
+func (f *GoFunc) Arity() (min, max int) {
+	if f.variadic {
+		return f.argNum, -1
+	}
+	return f.argNum, f.argNum
+}
+
+// Type returns the type of the Go function.
+func (f *GoFunc) Type() reflect.Type {
+	return f.typ
+}
+
+// String returns the signature of the function.
+func (f *GoFunc) String() string {
+	return f.signature
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// DefineGoFunc defines the Go function f as name. f may be variadic, and may
+// return nothing, a value, an error, or a value and an error. A non-nil error
+// aborts the evaluation.
+//
+// If the first parameter of f is a context.Context, f receives the context of
+// the evaluation, and the parameter is not passed in the expression.
 func (e *Environment) DefineGoFunc(name string, f interface{}) error {
 	t := reflect.TypeOf(f)
-	if t.Kind() != reflect.Func {
+	if t == nil || t.Kind() != reflect.Func {
 		return errors.New("not a function")
 	}
 
@@ -43,32 +110,65 @@ func (e *Environment) DefineGoFunc(name string, f interface{}) error {
 	numIn := t.NumIn()
 	numOut := t.NumOut()
 
-	if numOut > 1 {
+	if numOut > 2 {
 		return errors.New("too many return values")
 	}
+	if numOut == 2 && t.Out(1) != errorType {
+		return errors.New("the second return value must be error")
+	}
+	returnsErr := numOut > 0 && t.Out(numOut-1) == errorType
+
+	// offset of the first parameter passed in the expression
+	offset := 0
+	if numIn > 0 && t.In(0) == contextType {
+		offset = 1
+	}
+
+	argNum := numIn - offset
+	if t.IsVariadic() {
+		argNum--
+	}
 
 	gf := &GoFunc{
-		argNum: numIn,
-		call: func(arguments []interface{}) interface{} {
-			in := make([]reflect.Value, 0, numIn)
+		typ:       t,
+		argNum:    argNum,
+		variadic:  t.IsVariadic(),
+		signature: signature(name, t, offset),
+		call: func(ctx context.Context, arguments []interface{}) (interface{}, error) {
+			in := make([]reflect.Value, 0, offset+len(arguments))
+			if offset == 1 {
+				in = append(in, reflect.ValueOf(&ctx).Elem())
+			}
 			for i, inputArg := range arguments {
-				argDef := t.In(i)
+				var argDef reflect.Type
+				if i < argNum {
+					argDef = t.In(offset + i)
+				} else {
+					argDef = t.In(offset + argNum).Elem()
+				}
 				inputVal := reflect.ValueOf(inputArg)
 
 				converted, ok := TryConvert(inputVal, argDef)
 				if !ok {
-					return RuntimeError{msg: fmt.Sprintf("%s argument[%d] '%+v' %T is not compatible for %+v",
-						name, i, inputArg, inputArg, argDef)}
+					// the CallError wrapping it gives the name
+					return nil, runtimeError("argument[%d] '%+v' %T is not compatible for %+v",
+						i, inputArg, inputArg, argDef)
 				}
 
 				in = append(in, converted)
 			}
 
 			results := v.Call(in)
+			if returnsErr {
+				if err := results[len(results)-1]; !err.IsNil() {
+					return nil, err.Interface().(error)
+				}
+				results = results[:len(results)-1]
+			}
 			if len(results) == 0 {
-				return nil
+				return nil, nil
 			}
-			return results[0].Interface()
+			return results[0].Interface(), nil
 		},
 	}
 
@@ -76,6 +176,61 @@ func (e *Environment) DefineGoFunc(name string, f interface{}) error {
 	return nil
 }
 
+// signature formats the function type t like a Go declaration named name,
+// e.g. "any_entity(string, ...string) bool", omitting the first offset
+// parameters.
+func signature(name string, t reflect.Type, offset int) string {
+	var builder strings.Builder
+
+	builder.WriteString(name)
+	builder.WriteString("(")
+	for i := offset; i < t.NumIn(); i++ {
+		if i != offset {
+			builder.WriteString(", ")
+		}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			builder.WriteString("..." + t.In(i).Elem().String())
+		} else {
+			builder.WriteString(t.In(i).String())
+		}
+	}
+	builder.WriteString(")")
+
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		builder.WriteString(" " + t.Out(0).String())
+	default:
+		builder.WriteString(" (")
+		for i := 0; i < t.NumOut(); i++ {
+			if i != 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(t.Out(i).String())
+		}
+		builder.WriteString(")")
+	}
+
+	return builder.String()
+}
+
+// CallError is returned when a function called by an expression fails.
+type CallError struct {
+	// Name is the name of the function.
+	Name string
+	// Token is the closing parenthesis of the call.
+	Token *Token
+	Err   error
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("call %s: %s", e.Name, e.Err)
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
 // go1.17 以后可以使用 reflect.Value.CanConvert() 判断
 func TryConvert(v reflect.Value, t reflect.Type) (converted reflect.Value, ok bool) {
 	defer func() {
@@ -86,6 +241,49 @@ func TryConvert(v reflect.Value, t reflect.Type) (converted reflect.Value, ok bo
 		ok = false
 	}()
 
+	if !v.IsValid() {
+		// nil converts to the zero value of types that can be nil
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+			return reflect.Zero(t), true
+		default:
+			return reflect.Value{}, false
+		}
+	}
+
+	if t.Kind() == reflect.Slice && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) &&
+		!v.Type().ConvertibleTo(t) {
+		return convertSlice(v, t)
+	}
+
+	if t.Kind() == reflect.String && v.Kind() != reflect.String && v.Type().ConvertibleTo(numType) {
+		// Convert would turn an integer into the string of a rune
+		return reflect.Value{}, false
+	}
+
 	converted = v.Convert(t)
 	return converted, true
 }
+
+// convertSlice converts a list element by element, e.g. an array literal
+// ([]interface{}) passed to a Go function that accepts []string.
+func convertSlice(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	length := v.Len()
+	converted := reflect.MakeSlice(t, length, length)
+	for i := 0; i < length; i++ {
+		item := v.Index(i)
+		if item.Kind() == reflect.Interface {
+			item = item.Elem()
+		}
+		if !item.IsValid() {
+			return reflect.Value{}, false
+		}
+
+		c, ok := TryConvert(item, t.Elem())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		converted.Index(i).Set(c)
+	}
+	return converted, true
+}
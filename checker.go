@@ -0,0 +1,394 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Schema maps the names of the variables and functions of an expression to
+// their Go types. Functions have a func type, whose first context.Context
+// parameter, if any, is not passed in the expression.
+type Schema map[string]reflect.Type
+
+var (
+	boolType   = reflect.TypeOf(false)
+	stringType = reflect.TypeOf("")
+	intType    = reflect.TypeOf(int64(0))
+	listType   = reflect.TypeOf([]interface{}{})
+)
+
+// Check reports the type errors of expr, such as comparing a list with a
+// number or passing a number to a function expecting a string, without
+// evaluating it. The types of the variables and functions are given by
+// schema; the symbols missing from schema, nil and the values of type
+// interface{} are dynamically typed and never reported.
+//
+// All the errors, of KindType, are returned at once as an ErrorList.
+func Check(expr Expr, schema Schema) error {
+	c := &checker{schema: schema}
+	c.check(expr)
+	c.errors.Sort()
+	return c.errors.Err()
+}
+
+// checker is a visitor inferring the type of each expression. It returns
+// the type as a reflect.Type, nil meaning dynamically typed, and never
+// returns an error but records it, so that all the errors are reported.
+type checker struct {
+	schema Schema
+	errors ErrorList
+}
+
+var _ ExprVisitorObj = (*checker)(nil)
+
+func (c *checker) check(expr Expr) reflect.Type {
+	t, _ := expr.AcceptObj(c)
+	if t == nil {
+		return nil
+	}
+	return t.(reflect.Type)
+}
+
+func (c *checker) error(t *Token, format string, args ...interface{}) {
+	c.errors = append(c.errors, newError(KindType, t, fmt.Sprintf(format, args...)))
+}
+
+// errorAt records an error located at expr, or at token t if expr has no
+// span.
+func (c *checker) errorAt(expr Expr, t *Token, format string, args ...interface{}) {
+	err := locateExpr(&Error{Kind: KindType, Msg: fmt.Sprintf(format, args...)}, expr, t)
+	c.errors = append(c.errors, err.(*Error))
+}
+
+func (c *checker) VisitExprLiteralObj(expr *ExprLiteral) (interface{}, error) {
+	if expr.value == nil {
+		return nil, nil
+	}
+	return reflect.TypeOf(expr.value), nil
+}
+
+func (c *checker) VisitExprVariableObj(expr *ExprVariable) (interface{}, error) {
+	return known(c.schema[expr.name.lexeme]), nil
+}
+
+func (c *checker) VisitExprGroupingObj(expr *ExprGrouping) (interface{}, error) {
+	return c.check(expr.expression), nil
+}
+
+func (c *checker) VisitExprUnaryObj(expr *ExprUnary) (interface{}, error) {
+	right := c.check(expr.right)
+
+	switch expr.operator.typ {
+	case TokenMinus:
+		if right == nil {
+			return nil, nil
+		}
+		if !isNumberType(right) {
+			c.errorAt(expr.right, expr.operator, "operand of %s is %s, not number", expr.operator.lexeme, right)
+			return nil, nil
+		}
+		return known(numberType(right, right)), nil
+	default: // TokenBang
+		c.checkBool(expr.right, expr.operator, right)
+		return boolType, nil
+	}
+}
+
+func (c *checker) VisitExprLogicalObj(expr *ExprLogical) (interface{}, error) {
+	left := c.check(expr.left)
+	right := c.check(expr.right)
+
+	if expr.operator.typ == TokenQuestionQuestion {
+		if left != nil && left == right {
+			return left, nil
+		}
+		return nil, nil
+	}
+
+	c.checkBool(expr.left, expr.operator, left)
+	c.checkBool(expr.right, expr.operator, right)
+	return boolType, nil
+}
+
+// checkBool checks that operand, of type t, is a bool.
+func (c *checker) checkBool(operand Expr, operator *Token, t reflect.Type) {
+	if t != nil && t.Kind() != reflect.Bool {
+		c.errorAt(operand, operator, "operand of %s is %s, not bool", operator.lexeme, t)
+	}
+}
+
+//revive:disable:cyclomatic
+func (c *checker) VisitExprBinaryObj(expr *ExprBinary) (interface{}, error) {
+	left := c.check(expr.left)
+	right := c.check(expr.right)
+	operator := expr.operator
+
+	switch operator.typ {
+	case TokenEqualEqual, TokenBangEqual, TokenContainsAny, TokenIntersects, TokenContainsAll, TokenSubsetOf:
+		return boolType, nil
+	case TokenIn, TokenNotIn:
+		c.checkContainer(operator, right, left, "right")
+		return boolType, nil
+	case TokenContains:
+		c.checkContainer(operator, left, right, "left")
+		return boolType, nil
+	}
+
+	if left == nil || right == nil {
+		if operator.typ == TokenGreater || operator.typ == TokenGreaterEqual ||
+			operator.typ == TokenLess || operator.typ == TokenLessEqual {
+			return boolType, nil
+		}
+		return nil, nil
+	}
+
+	if operator.typ == TokenPlus && left.Kind() == reflect.String && right.Kind() == reflect.String {
+		return stringType, nil
+	}
+	if !isNumberType(left) || !isNumberType(right) {
+		c.error(operator, "mismatched types %s %s %s", left, operator.lexeme, right)
+		return nil, nil
+	}
+
+	switch operator.typ {
+	case TokenGreater, TokenGreaterEqual, TokenLess, TokenLessEqual:
+		return boolType, nil
+	default:
+		return numberType(left, right), nil
+	}
+}
+
+//revive:enable:cyclomatic
+
+// checkContainer checks the operands of "in" and "contains", the container
+// being the operand on side, "left" or "right".
+func (c *checker) checkContainer(operator *Token, container, item reflect.Type, side string) {
+	if container == nil {
+		return
+	}
+	switch container.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.String:
+		if item != nil && item.Kind() != reflect.String {
+			other := "left"
+			if side == "left" {
+				other = "right"
+			}
+			c.error(operator, "%s operand of %s is %s, want string", other, operator.lexeme, item)
+		}
+	default:
+		c.error(operator, "%s operand of %s is %s, want list or string", side, operator.lexeme, container)
+	}
+}
+
+func (c *checker) VisitExprCallObj(expr *ExprCall) (interface{}, error) {
+	args := make([]reflect.Type, 0, len(expr.arguments))
+	for _, argument := range expr.arguments {
+		args = append(args, c.check(argument))
+	}
+
+	variable, ok := expr.callee.(*ExprVariable)
+	if !ok {
+		c.check(expr.callee)
+		return nil, nil
+	}
+	name := variable.name.lexeme
+	t := c.schema[name]
+	if t == nil {
+		return nil, nil
+	}
+	if t.Kind() != reflect.Func {
+		c.error(variable.name, "%s (%s) is not a function", name, t)
+		return nil, nil
+	}
+
+	offset := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		offset = 1
+	}
+	min := t.NumIn() - offset
+	if t.IsVariadic() {
+		min--
+	}
+	if len(args) < min || (!t.IsVariadic() && len(args) > min) {
+		want := plural(min, "argument")
+		if t.IsVariadic() {
+			want = "at least " + want
+		}
+		c.error(expr.paren, "%s expects %s, got %d", signature(name, t, offset), want, len(args))
+	} else {
+		for i, arg := range args {
+			var param reflect.Type
+			if i < min {
+				param = t.In(offset + i)
+			} else {
+				param = t.In(t.NumIn() - 1).Elem()
+			}
+			if !assignable(arg, param) {
+				c.errorAt(expr.arguments[i], expr.paren, "%s argument[%d] %s is not compatible for %s", name, i, arg, param)
+			}
+		}
+	}
+
+	if t.NumOut() == 0 || t.Out(0) == errorType {
+		return nil, nil
+	}
+	return known(t.Out(0)), nil
+}
+
+func (c *checker) VisitExprGetObj(expr *ExprGet) (interface{}, error) {
+	t := indirectType(c.check(expr.object))
+	if t == nil {
+		return nil, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			return known(t.Elem()), nil
+		}
+	case reflect.Struct:
+		index, ok := structFields(t)[expr.name.lexeme]
+		if !ok {
+			c.error(expr.name, "no such field %s in %s", expr.name.lexeme, t)
+			return nil, nil
+		}
+		return known(t.FieldByIndex(index).Type), nil
+	}
+
+	c.error(expr.name, "member %s of %s", expr.name.lexeme, t)
+	return nil, nil
+}
+
+func (c *checker) VisitExprIndexObj(expr *ExprIndex) (interface{}, error) {
+	t := indirectType(c.check(expr.object))
+	var index, end reflect.Type
+	if expr.index != nil {
+		index = c.check(expr.index)
+	}
+	if expr.end != nil {
+		end = c.check(expr.end)
+	}
+	if t == nil {
+		return nil, nil
+	}
+
+	if t.Kind() == reflect.Map && expr.colon == nil {
+		if t.Key().Kind() != reflect.String || (index != nil && index.Kind() != reflect.String) {
+			c.error(expr.bracket, "map index %s of %s is not string", index, t)
+			return nil, nil
+		}
+		return known(t.Elem()), nil
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array:
+	default:
+		c.error(expr.bracket, "index of non-indexable value %s", t)
+		return nil, nil
+	}
+	for _, bound := range []struct {
+		expr Expr
+		t    reflect.Type
+	}{{expr.index, index}, {expr.end, end}} {
+		if bound.t != nil && !isIntegerType(bound.t) && bound.t.Kind() != reflect.Float64 {
+			c.errorAt(bound.expr, expr.bracket, "index %s is not integer", bound.t)
+		}
+	}
+
+	switch {
+	case t.Kind() == reflect.String:
+		return stringType, nil
+	case expr.colon != nil:
+		return reflect.SliceOf(t.Elem()), nil
+	default:
+		return known(t.Elem()), nil
+	}
+}
+
+func (c *checker) VisitExprArrayObj(expr *ExprArray) (interface{}, error) {
+	for _, item := range expr.items {
+		c.check(item)
+	}
+	return listType, nil
+}
+
+func (c *checker) VisitExprConditionalObj(expr *ExprConditional) (interface{}, error) {
+	c.checkBool(expr.condition, expr.question, c.check(expr.condition))
+	then := c.check(expr.then)
+	otherwise := c.check(expr.otherwise)
+	if then != nil && then == otherwise {
+		return then, nil
+	}
+	return nil, nil
+}
+
+func (c *checker) VisitExprBadObj(expr *ExprBad) (interface{}, error) {
+	return nil, nil
+}
+
+// plural returns n followed by noun, in the plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// known returns t, or nil for the interface types, whose values are
+// dynamically typed.
+func known(t reflect.Type) reflect.Type {
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	return t
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func isIntegerType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
+func isNumberType(t reflect.Type) bool {
+	return isIntegerType(t) || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
+
+// numberType is the type of the result of an arithmetic operation, see
+// arithmetic.
+func numberType(a, b reflect.Type) reflect.Type {
+	if isIntegerType(a) && isIntegerType(b) {
+		return intType
+	}
+	return numType
+}
+
+// assignable reports whether a value of type from can be passed as a
+// parameter of type to, see TryConvert.
+func assignable(from, to reflect.Type) bool {
+	if from == nil || to.Kind() == reflect.Interface {
+		return true
+	}
+
+	switch {
+	case to.Kind() == reflect.String:
+		return from.Kind() == reflect.String
+	case isNumberType(to):
+		return isNumberType(from)
+	case to.Kind() == reflect.Slice && (from.Kind() == reflect.Slice || from.Kind() == reflect.Array):
+		return assignable(known(from.Elem()), to.Elem())
+	default:
+		return from.ConvertibleTo(to)
+	}
+}
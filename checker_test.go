@@ -0,0 +1,152 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_check(t *testing.T) {
+	env := NewEnvironment()
+	funcs := map[string]interface{}{
+		"ner_entities": func(name string) []string { return nil },
+		"has_prefix":   strings.HasPrefix,
+		"any_entity":   func(ctx context.Context, names ...string) bool { return false },
+		"fail":         func() error { return nil },
+		"anything":     func(v interface{}) interface{} { return v },
+		"prefixed":     func(prefix string, names ...string) bool { return false },
+	}
+	for name, f := range funcs {
+		if err := env.DefineGoFunc(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.Define("user", &testUser{Name: "lily"})
+	env.Define("price", 89.5)
+	env.Define("count", 3)
+	env.Define("doc", map[string]interface{}{})
+	env.Define("dynamic", nil)
+	schema := env.Schema()
+
+	valid := []string{
+		`ner_entities("肤质") == ["干性"] and price > 50`,
+		`count + 1 > price * 2 and -count < 0`,
+		`!has_prefix(user.Name, "l") or any_entity() or any_entity("a", "b")`,
+		`"干性" in ner_entities("肤质") and "a" in "abc" and ner_entities("肤质") contains_any ["a"]`,
+		`user.profile?.Age ?? 0 > 18 and user.id + user.tags[0] == "a"`,
+		`ner_entities("肤质")[0] + "a" == "b" and ner_entities("肤质")[1:] == []`,
+		`doc.a.b > 3 and doc["x"] and anything(1) + 1 and dynamic * 2`,
+		`(price > 80 ? "high" : "low") + "!"`,
+		`unknown(1, "a") > unknown2`,
+		`fail() == nil`,
+	}
+	for _, src := range valid {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+			if err := Check(e, schema); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+		})
+	}
+
+	invalid := []struct {
+		src    string
+		expect []string
+	}{
+		{src: `ner_entities("肤质") > 3`,
+			expect: []string{"[line 1:20] mismatched types []string > int64"}},
+		{src: `!5 or price and "a"`,
+			expect: []string{
+				"[line 1:2] operand of ! is int64, not bool",
+				"[line 1:7] operand of and is float64, not bool",
+				"[line 1:17] operand of and is string, not bool",
+			}},
+		{src: `has_prefix(1, "a") and has_prefix("a")`,
+			expect: []string{
+				"[line 1:12] has_prefix argument[0] int64 is not compatible for string",
+				"[line 1:38] has_prefix(string, string) bool expects 2 arguments, got 1",
+			}},
+		{src: `prefixed() or 1 in "abc" or "abc" contains 1 or count contains 1`,
+			expect: []string{
+				"[line 1:10] prefixed(string, ...string) bool expects at least 1 argument, got 0",
+				"[line 1:17] left operand of in is int64, want string",
+				"[line 1:35] right operand of contains is int64, want string",
+				"[line 1:55] left operand of contains is int, want list or string",
+			}},
+		{src: `any_entity("a", 1)`,
+			expect: []string{"[line 1:17] any_entity argument[1] int64 is not compatible for string"}},
+		{src: `user.Nmae == "lily" or price.x`,
+			expect: []string{
+				"[line 1:6] no such field Nmae in expr.testUser",
+				"[line 1:30] member x of float64",
+			}},
+		{src: `1 in count or "a" + 1 == -"b" or price(1)`,
+			expect: []string{
+				"[line 1:3] right operand of in is int, want list or string",
+				"[line 1:19] mismatched types string + int64",
+				"[line 1:27] operand of - is string, not number",
+				"[line 1:34] price (float64) is not a function",
+			}},
+		{src: `(count ? 1 : 2) > ner_entities(["a"])`,
+			expect: []string{
+				"[line 1:2] operand of ? is int, not bool",
+				"[line 1:17] mismatched types int64 > []string",
+				"[line 1:32] ner_entities argument[0] []interface {} is not compatible for string",
+			}},
+		{src: `price[0] or ner_entities("a")["x"]`,
+			expect: []string{
+				"[line 1:8] index of non-indexable value float64",
+				"[line 1:13] operand of or is string, not bool",
+				"[line 1:31] index string is not integer",
+			}},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+
+			err = Check(e, schema)
+			var errs ErrorList
+			if !errors.As(err, &errs) {
+				t.Fatalf("want ErrorList, got %v", err)
+			}
+			var got []string
+			for _, e := range errs {
+				if e.Kind != KindType {
+					t.Fatalf("want type error, got %s", e.Kind)
+				}
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Fatalf("want errors\n%s\ngot\n%s", strings.Join(tc.expect, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func Test_compile_schema(t *testing.T) {
+	opts := []Option{
+		WithFunc("ner_entities", func(name string) []string { return nil }),
+		WithSchema(Schema{"price": reflect.TypeOf(0.0)}),
+	}
+
+	if _, err := Compile(`ner_entities("功效") contains "补水" and price < 100`, opts...); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Compile(`ner_entities(1) and price < "100"`, opts...)
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindType {
+		t.Fatalf("want type error, got %v", err)
+	}
+	if n := len(err.(ErrorList)); n != 3 {
+		t.Fatalf("want 3 errors, got %d: %v", n, err)
+	}
+}
@@ -0,0 +1,139 @@
+// Command exprfmt formats rule files, each holding one expression, in the
+// canonical style of expr.FormatSource.
+//
+// Usage:
+//
+//	exprfmt [-l] [-w] [-zh] [file ...]
+//
+// Without files, exprfmt formats its standard input.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nuzar/expr"
+)
+
+var (
+	list    = flag.Bool("l", false, "list the files whose formatting differs from exprfmt's")
+	write   = flag.Bool("w", false, "write the result to the file instead of the standard output")
+	chinese = flag.Bool("zh", false, "accept the Chinese keywords")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: exprfmt [-l] [-w] [-zh] [file ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var opts []expr.ScannerOption
+	if *chinese {
+		opts = append(opts, expr.WithKeywords(expr.DefaultKeywords, expr.OperatorAliases, expr.ChineseKeywords))
+	}
+
+	status := 0
+	if flag.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err == nil {
+			err = format("<stdin>", src, opts, false)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+	for _, path := range flag.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = format(path, src, opts, true)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+	os.Exit(status)
+}
+
+func format(path string, src []byte, opts []expr.ScannerOption, isFile bool) error {
+	res, err := expr.FormatSource(string(src), opts...)
+	if err != nil {
+		return fmt.Errorf("%s:\n%s", path, describe(string(src), err))
+	}
+
+	changed := !bytes.Equal(src, []byte(res))
+	if *list && changed {
+		fmt.Println(path)
+	}
+	if *write && isFile {
+		if changed {
+			return writeFile(path, []byte(res))
+		}
+		return nil
+	}
+	if !*list {
+		fmt.Print(res)
+	}
+	return nil
+}
+
+// writeFile replaces the content of the file path by data atomically, like
+// gofmt: data is written to a temporary file in the same directory, with the
+// mode of the original file, which is renamed over the original, so that an
+// interrupted run never leaves a truncated rule file.
+func writeFile(path string, data []byte) (err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// describe shows the positions of the errors in src.
+func describe(src string, err error) string {
+	var errs expr.ErrorList
+	if !errors.As(err, &errs) {
+		var e *expr.Error
+		if !errors.As(err, &e) {
+			return err.Error()
+		}
+		errs = expr.ErrorList{e}
+	}
+
+	var buffer bytes.Buffer
+	for i, e := range errs {
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(e.Format(src))
+	}
+	return buffer.String()
+}
@@ -17,6 +17,10 @@ func main() {
 		"Unary    : operator *Token, right Expr",
 		"Array    : bracket *Token, items []Expr",
 		"Variable : name *Token",
+		"Get      : object Expr, name *Token, optional bool",
+		"Index    : object Expr, bracket *Token, index Expr, colon *Token, end Expr",
+		"Bad      : from *Token, to *Token",
+		"Conditional : condition Expr, question *Token, then Expr, otherwise Expr",
 	})
 
 	// defineAst(".", "Stmt", []string{
@@ -39,6 +43,12 @@ func defineAst(outputDir, basename string, types []string) error {
 	buffer.WriteString(fmt.Sprintf("	AcceptStr(visitor %sVisitorStr) string\n", basename))
 	// 这个是用于执行语句的visitor。没有泛型，定义不了通用的visitor...
 	buffer.WriteString(fmt.Sprintf("	AcceptObj(visitor %sVisitorObj) (interface{}, error)\n", basename))
+	// 用于 Walk 和 Rewrite 遍历、替换子节点
+	buffer.WriteString(fmt.Sprintf("	children() []%s\n", basename))
+	buffer.WriteString(fmt.Sprintf("	withChildren(children []%s) %s\n", basename, basename))
+	// 节点在源码中的位置，由 Parser 设置
+	buffer.WriteString("	Span() Span\n")
+	buffer.WriteString("	setSpan(span Span)\n")
 	buffer.WriteString("}\n\n")
 
 	defineVisitor(buffer, basename, types)
@@ -80,6 +90,7 @@ func defineType(builder *bytes.Buffer, basename, typename, fieldlist string) {
 	for _, f := range fields {
 		builder.WriteString(fmt.Sprintf("	%s %s\n", f.Name, f.Type))
 	}
+	builder.WriteString("	span Span\n")
 	builder.WriteString("}\n\n")
 
 	builder.WriteString(fmt.Sprintf("func New%s(", fulltypename))
@@ -108,6 +119,73 @@ func defineType(builder *bytes.Buffer, basename, typename, fieldlist string) {
 	builder.WriteString(
 		fmt.Sprintf("	return visitor.Visit%sObj(e)\n", fulltypename))
 	builder.WriteString("}\n\n")
+
+	// exported accessors
+	for _, f := range fields {
+		exported := strings.ToUpper(f.Name[:1]) + f.Name[1:]
+		builder.WriteString(fmt.Sprintf("func (e *%s) %s() %s {\n", fulltypename, exported, f.Type))
+		builder.WriteString(fmt.Sprintf("	return e.%s\n", f.Name))
+		builder.WriteString("}\n\n")
+	}
+
+	builder.WriteString(fmt.Sprintf("func (e *%s) Span() Span {\n", fulltypename))
+	builder.WriteString("	return e.span\n")
+	builder.WriteString("}\n\n")
+	builder.WriteString(fmt.Sprintf("func (e *%s) setSpan(span Span) {\n", fulltypename))
+	builder.WriteString("	e.span = span\n")
+	builder.WriteString("}\n\n")
+
+	defineChildren(builder, basename, fulltypename, fields)
+}
+
+// defineChildren generates the methods listing the sub-expressions of a
+// node, and returning a copy of the node with other sub-expressions.
+func defineChildren(builder *bytes.Buffer, basename, fulltypename string, fields []Field) {
+	var children []string
+	for _, f := range fields {
+		switch f.Type {
+		case basename:
+			children = append(children, "e."+f.Name)
+		case "[]" + basename:
+			children = append(children, "e."+f.Name+"...")
+		}
+	}
+
+	builder.WriteString(fmt.Sprintf("func (e *%s) children() []%s {\n", fulltypename, basename))
+	switch {
+	case len(children) == 0:
+		builder.WriteString("	return nil\n")
+	case strings.HasSuffix(children[len(children)-1], "..."):
+		builder.WriteString(fmt.Sprintf("	return append([]%s{%s}, %s)\n", basename,
+			strings.Join(children[:len(children)-1], ", "), children[len(children)-1]))
+	default:
+		builder.WriteString(fmt.Sprintf("	return []%s{%s}\n", basename, strings.Join(children, ", ")))
+	}
+	builder.WriteString("}\n\n")
+
+	builder.WriteString(fmt.Sprintf("func (e *%s) withChildren(children []%s) %s {\n", fulltypename, basename, basename))
+	if len(children) == 0 {
+		builder.WriteString("	return e\n")
+		builder.WriteString("}\n\n")
+		return
+	}
+	builder.WriteString("	t := *e\n")
+	offset := "0"
+	i := 0
+	for _, f := range fields {
+		switch f.Type {
+		case basename:
+			builder.WriteString(fmt.Sprintf("	t.%s = children[%d]\n", f.Name, i))
+			i++
+			offset = fmt.Sprint(i)
+		case "[]" + basename:
+			end := fmt.Sprintf("%s+len(e.%s)", offset, f.Name)
+			builder.WriteString(fmt.Sprintf("	t.%s = children[%s:%s]\n", f.Name, offset, end))
+			offset = end
+		}
+	}
+	builder.WriteString("	return &t\n")
+	builder.WriteString("}\n\n")
 }
 
 func defineVisitor(buffer *bytes.Buffer, basename string, types []string) {
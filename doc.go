@@ -1,7 +1,10 @@
 /*
 条件表达式支持的数据类型
-数字 `123`
-字符串 `"12ab你好"`
+数字 整数 `123`, `0x1F`, `1_000_000` 为 int64；小数 `1.5`, `.5`, `1e6` 为 float64
+两个整数运算的结果仍为整数，溢出时报错；整数和小数运算时按小数计算。Go 函数返回的各种整数、无符号整数和浮点数都可以参与运算，
+超出 int64 的 uint64 按精确值比较和计算
+字符串 `"12ab你好"`, `'12ab你好'` 双引号和单引号字符串支持转义 `\"`, `\'`, `\\`, `\n`, `\t`, `\r`, `\uXXXX`（不接受代理项 `\ud800`-`\udfff`）；
+反引号字符串 `C:\dir` 为原始字符串，不处理转义。字符串可以跨行
 布尔值 `true`, `false`
 列表 `["a", "b", "c"]` 列表的元素是数字、字符串或布尔值，元素的类型可以不一致。
 
@@ -11,20 +14,65 @@
 `-` 负，操作对象为布尔值
 
 双目运算
+`+` 加，操作对象为数字；两边都是字符串时为拼接
+`-` 减，操作对象为数字
+`*` 乘，操作对象为数字
+`/` 除，操作对象为数字，两个整数相除为整除（`7 / 2 == 3`），除数为0时报错
+`%` 取余，操作对象为数字，除数为0时报错
 `and` 且，操作对象为布尔值
 `or` 或，操作对象为布尔值
-`==` 等于，支持所有类型
+`==` 等于，支持所有类型。列表按顺序逐个比较元素，可以和函数返回的 Go 切片（如 []string）比较
 `!=` 不等于，支持所有类型
 `>` 大于，支持数字
 `>=` 大于等于，支持数字
 `<` 小于，支持数字
 `<=` 小于等于，支持数字
+`in` 属于，右边为列表时判断是否包含左边的元素，右边为字符串时判断左边是否为其子串
+`not in` 不属于，与 `in` 相反
+`contains` 包含，与 `in` 的左右两边相反
+`contains_any` 左边的列表至少包含右边列表的一个元素
+`contains_all` 左边的列表包含右边列表的所有元素
+`subset_of` 左边列表的所有元素都在右边的列表中
+`intersects` 两个列表有共同的元素
+集合运算不考虑元素的顺序，不是列表的操作对象当作只有一个元素的列表处理
+
+运算符的其他写法
+`&&` 同 `and`，`||` 同 `or`，`not` 同 `!`，`=` 同 `==`，`<>` 同 `!=`
+使用 WithKeywords(DefaultKeywords, OperatorAliases, ChineseKeywords) 时还可以使用中文关键字：
+`且` 同 `and`，`或` 同 `or`，`非` 同 `!`，`包含` 同 `contains`，中文关键字和标识符之间需要用空格隔开
+
+空值运算
+`a ?? b` a 为空值时取 b，否则取 a，如 `ner_entities("肤质") ?? []`
+`a?.b` a 为空值时结果为空值，否则访问 a 的成员 b。a 为空值时还会跳过其后的成员访问、下标和调用，
+如 doc 为空值时 `doc?.profile.age` 为空值；括号会截断，`(doc?.profile).age` 仍会报错
+map 中不存在的 key 的值为空值
+
+条件运算
+`cond ? a : b` cond 为真时取 a，否则取 b，只计算被选中的一边。优先级最低，右结合，
+如 `type == "精华" ? 1 : type == "面膜" ? 2 : 3`
 
 分组
 `()` 支持所有类型，用于控制运算符的优先级
 
+成员访问
+`user.profile.age` 访问变量的成员，变量可以是 key 为字符串的 map、结构体或它们的指针。
+结构体字段可以用 `expr:"name"` 标签重命名，`expr:"-"` 的字段和未导出的字段不可访问
+
+下标和切片
+`list[0]` 取列表的元素，负数下标从末尾开始计数，如 `list[-1]` 为最后一个元素
+`list[1:3]` 取列表的切片，省略的边界分别为开头和末尾
+字符串按字符（而不是字节）取下标和切片，如 `"面膜"[0] == "面"`
+`m["key"]` 取 key 为字符串的 map 的值
+下标越界时报运行时错误
+
 函数调用
 `identifier(arg)` 函数可以接收任意多个参数，返回1个值。函数的参数类型和返回值类型要看具体的函数定义
+Go 函数可以是可变参数函数，如 `func(prefix string, names ...string) bool`
+Go 函数的第一个参数可以是 context.Context，此时会传入求值时的 context，表达式中不需要传这个参数
+Go 函数可以返回 (值, error)，error 不为 nil 时终止求值，返回的错误为 *CallError，记录了函数名和调用位置
+
+注释
+`//` 之后到行尾为注释；块注释以 `/*` 开始，以星号加斜杠结束，可以跨行
 
 可用的函数包括:
 获取已识别的某实体的值
@@ -33,6 +81,9 @@
 示例：
 产品类型为面膜，肤质为干性或产品功效为补水
 `ner_entities("产品类型") == ["面膜"] and (ner_entities("肤质")==["干性"] or ner_entities("功效")==["补水"])`
+
+肤质包含干性或混合性
+`ner_entities("肤质") contains_any ["干性", "混合性"]`
 */
 
 package expr
@@ -1,13 +1,35 @@
 package expr
 
 import (
-	"fmt"
+	"reflect"
+	"sort"
 )
 
-// Environment stores global symbols
-
+// Environment stores global symbols.
+//
+// Environments can be nested: a long-lived environment holding the
+// registered Go functions can enclose a cheap per-evaluation environment
+// holding the request data. Symbols not found in an environment are looked up
+// in the enclosing one.
 type Environment struct {
-	values map[string]interface{}
+	values    map[string]interface{}
+	enclosing *Environment
+}
+
+func NewEnvironment() *Environment {
+	return &Environment{
+		values: make(map[string]interface{}),
+	}
+}
+
+// NewEnclosedEnvironment returns an empty environment falling back to
+// enclosing. Symbols defined in it shadow those of enclosing, which is left
+// untouched.
+func NewEnclosedEnvironment(enclosing *Environment) *Environment {
+	return &Environment{
+		values:    make(map[string]interface{}),
+		enclosing: enclosing,
+	}
 }
 
 func (e *Environment) Define(name string, value interface{}) {
@@ -15,10 +37,60 @@ func (e *Environment) Define(name string, value interface{}) {
 }
 
 func (e *Environment) Get(name *Token) (interface{}, error) {
-	v, ok := e.values[name.lexeme]
+	v, ok := e.lookup(name.lexeme)
 	if ok {
 		return v, nil
 	}
 
-	return nil, RuntimeError{msg: fmt.Sprintf("undefined symbol %s", name.lexeme)}
+	return nil, RuntimeErrWithToken(name, "undefined symbol "+name.lexeme)
+}
+
+// Names returns the sorted names of the symbols of e and its enclosing
+// environments.
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for env := e; env != nil; env = env.enclosing {
+		for name := range env.values {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Schema returns the types of the symbols of e and its enclosing
+// environments, with the Go functions defined by DefineGoFunc as their func
+// types. Other callables and nil values have a nil type, meaning they are
+// dynamically typed.
+func (e *Environment) Schema() Schema {
+	schema := make(Schema)
+	for env := e; env != nil; env = env.enclosing {
+		for name, value := range env.values {
+			if _, ok := schema[name]; ok {
+				continue
+			}
+			switch v := value.(type) {
+			case *GoFunc:
+				schema[name] = v.Type()
+			case Callable, nil:
+				schema[name] = nil
+			default:
+				schema[name] = reflect.TypeOf(v)
+			}
+		}
+	}
+	return schema
+}
+
+func (e *Environment) lookup(name string) (interface{}, bool) {
+	for env := e; env != nil; env = env.enclosing {
+		if v, ok := env.values[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
 }
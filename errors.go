@@ -0,0 +1,226 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrorKind tells in which stage an Error occurred.
+type ErrorKind int
+
+const (
+	KindScan ErrorKind = iota + 1
+	KindParse
+	KindRuntime
+	// KindType is reported by Check.
+	KindType
+	// KindResolve is reported by Resolve.
+	KindResolve
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindScan:
+		return "scan error"
+	case KindParse:
+		return "parse error"
+	case KindRuntime:
+		return "runtime error"
+	case KindType:
+		return "type error"
+	case KindResolve:
+		return "resolve error"
+	default:
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+}
+
+// Error is an error in an expression, located in its source. Errors
+// without a position, e.g. returned by functions, have a zero Line.
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+	// Start and End are the byte offsets of the offending span of the source.
+	Start, End int
+	// Line and Column are the 1-based position of Start, with Column counted
+	// in characters.
+	Line, Column int
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// newError returns an error located at token t.
+func newError(kind ErrorKind, t *Token, msg string) *Error {
+	return spanError(kind, t.span(), msg)
+}
+
+// spanError returns an error located at span s.
+func spanError(kind ErrorKind, s Span, msg string) *Error {
+	return &Error{
+		Kind:   kind,
+		Msg:    msg,
+		Start:  s.Start,
+		End:    s.End,
+		Line:   s.Line,
+		Column: s.Column,
+	}
+}
+
+// runtimeError returns a runtime error without position, see locate.
+func runtimeError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindRuntime, Msg: fmt.Sprintf(format, args...)}
+}
+
+func RuntimeErrWithToken(t *Token, msg string) error {
+	return newError(KindRuntime, t, msg)
+}
+
+// locate sets the position of err to token t, unless it is already known.
+func locate(err error, t *Token) error {
+	return locateSpan(err, t.span())
+}
+
+// locateExpr sets the position of err to the span of expr, or to token t if
+// expr has no span, unless the position of err is already known.
+func locateExpr(err error, expr Expr, t *Token) error {
+	if s := expr.Span(); s.IsValid() {
+		return locateSpan(err, s)
+	}
+	return locate(err, t)
+}
+
+func locateSpan(err error, s Span) error {
+	if e, ok := err.(*Error); ok && e.Line == 0 {
+		located := *spanError(e.Kind, s, e.Msg)
+		located.Err = e.Err
+		return &located
+	}
+	return err
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("[line %d:%d] %s", e.Line, e.Column, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Format returns the error followed by the line of src it occurred in, with
+// the offending span underlined by carets, see Test_error_format:
+//
+//	[line 1:20] 3 (int64) is not list or string
+//	ner_entities("肤质") in 3
+//	                     ^^
+func (e *Error) Format(src string) string {
+	if e.Line == 0 || e.Start < 0 || e.Start > len(src) {
+		return e.Error()
+	}
+
+	lineStart := strings.LastIndexByte(src[:e.Start], '\n') + 1
+	lineEnd := len(src)
+	if i := strings.IndexByte(src[e.Start:], '\n'); i >= 0 {
+		lineEnd = e.Start + i
+	}
+	end := e.End
+	if end > lineEnd {
+		end = lineEnd
+	}
+	if end < e.Start {
+		end = e.Start
+	}
+
+	var builder strings.Builder
+	builder.WriteString(e.Error())
+	builder.WriteString("\n")
+	builder.WriteString(src[lineStart:lineEnd])
+	builder.WriteString("\n")
+	for _, r := range src[lineStart:e.Start] {
+		if r == '\t' {
+			builder.WriteRune('\t')
+		} else {
+			builder.WriteString(strings.Repeat(" ", runeWidth(r)))
+		}
+	}
+	width := 0
+	for _, r := range src[e.Start:end] {
+		width += runeWidth(r)
+	}
+	if width == 0 {
+		width = 1
+	}
+	builder.WriteString(strings.Repeat("^", width))
+
+	return builder.String()
+}
+
+// ErrorList is a list of errors, returned when all the errors of an
+// expression are reported at once.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+// Err returns l as an error, or nil if l is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// As sets target to the first error when target is a **Error, so that
+// errors.As works on an ErrorList as on a single Error.
+func (l ErrorList) As(target interface{}) bool {
+	t, ok := target.(**Error)
+	if !ok || len(l) == 0 {
+		return false
+	}
+	*t = l[0]
+	return true
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, 0, len(l))
+	for _, e := range l {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Sort sorts the errors by position.
+func (l ErrorList) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Start < l[j].Start
+	})
+}
+
+// runeWidth returns the number of columns r takes in a terminal, which is 2
+// for East Asian wide characters like Chinese.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hangul, r),
+		unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r),
+		r >= 0x3000 && r <= 0x303f, // CJK symbols and punctuation
+		r >= 0xff00 && r <= 0xff60, // fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6:
+		return 2
+	case unicode.Is(unicode.Mn, r):
+		return 0
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,307 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_error_position(t *testing.T) {
+	nerEntities := func(name string) []string {
+		return nil
+	}
+
+	testCases := []struct {
+		src    string
+		kind   ErrorKind
+		msg    string
+		start  int
+		end    int
+		line   int
+		column int
+	}{
+		{src: "true and\n  x # 1", kind: KindScan, msg: "unexpected character #",
+			start: 13, end: 14, line: 2, column: 5},
+		{src: "1 +\n\"abc", kind: KindScan, msg: "unterminated string",
+			start: 4, end: 8, line: 2, column: 1},
+		{src: `"a\qb" == "c"`, kind: KindScan, msg: `invalid escape sequence \q`,
+			start: 2, end: 4, line: 1, column: 3},
+		{src: "1 ==\n \"补\\u12G4\"", kind: KindScan, msg: `invalid unicode escape \u12`,
+			start: 10, end: 14, line: 2, column: 4},
+		{src: `"\ud800" == "a"`, kind: KindScan, msg: `invalid unicode escape \ud800`,
+			start: 1, end: 7, line: 1, column: 2},
+		{src: "'abc\" == 1", kind: KindScan, msg: "unterminated string",
+			start: 0, end: 10, line: 1, column: 1},
+		{src: "1 + 0x", kind: KindScan, msg: "invalid hexadecimal number 0x",
+			start: 4, end: 6, line: 1, column: 5},
+		{src: "1e+ > 2", kind: KindScan, msg: "invalid exponent in number 1e+",
+			start: 0, end: 3, line: 1, column: 1},
+		{src: "x == 9223372036854775808", kind: KindScan, msg: "number out of range 9223372036854775808",
+			start: 5, end: 24, line: 1, column: 6},
+		{src: "/* a\n b */ 1 +\n  # 2", kind: KindScan, msg: "unexpected character #",
+			start: 17, end: 18, line: 3, column: 3},
+		{src: "1 == 1 /* a\n", kind: KindScan, msg: "unterminated comment",
+			start: 7, end: 12, line: 1, column: 8},
+		{src: "(1 + ", kind: KindParse, msg: "at end: Expect expression.",
+			start: 5, end: 5, line: 1, column: 6},
+		{src: "ner_entities(\"肤质\" 1)", kind: KindParse, msg: "at '1': Expect ')' after arguments.",
+			start: 22, end: 23, line: 1, column: 19},
+		{src: `ner_entities("肤质") in 3`, kind: KindRuntime, msg: "3 (int64) is not list or string",
+			start: 23, end: 25, line: 1, column: 20},
+		{src: "true and\n\t\"面膜\" > 2", kind: KindRuntime, msg: "面膜 > 2 is not number",
+			start: 19, end: 20, line: 2, column: 7},
+		{src: `unknown == 1`, kind: KindRuntime, msg: "undefined symbol unknown",
+			start: 0, end: 7, line: 1, column: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			program, err := Compile(tc.src, WithFunc("ner_entities", nerEntities))
+			if err == nil {
+				_, err = program.Eval(nil)
+			}
+
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("want *Error, got %T %v", err, err)
+			}
+			if e.Kind != tc.kind || e.Msg != tc.msg {
+				t.Fatalf("want %s %q, got %s %q", tc.kind, tc.msg, e.Kind, e.Msg)
+			}
+			if e.Start != tc.start || e.End != tc.end || e.Line != tc.line || e.Column != tc.column {
+				t.Fatalf("want span [%d, %d) at %d:%d, got [%d, %d) at %d:%d",
+					tc.start, tc.end, tc.line, tc.column, e.Start, e.End, e.Line, e.Column)
+			}
+		})
+	}
+}
+
+func Test_error_format(t *testing.T) {
+	testCases := []struct {
+		src    string
+		expect string
+	}{
+		{
+			src: `ner_entities("肤质") in 3`,
+			expect: "[line 1:20] 3 (int64) is not list or string\n" +
+				"ner_entities(\"肤质\") in 3\n" +
+				"                     ^^",
+		},
+		{
+			src: "true and\n\t\"面膜\" > 2",
+			expect: "[line 2:7] 面膜 > 2 is not number\n" +
+				"\t\"面膜\" > 2\n" +
+				"\t       ^",
+		},
+		{
+			src: "(1 + ",
+			expect: "[line 1:6] at end: Expect expression.\n" +
+				"(1 + \n" +
+				"     ^",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			program, err := Compile(tc.src, WithFunc("ner_entities", func(string) []string { return nil }))
+			if err == nil {
+				_, err = program.Eval(nil)
+			}
+
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("want *Error, got %T %v", err, err)
+			}
+			if got := e.Format(tc.src); got != tc.expect {
+				t.Fatalf("want\n%s\ngot\n%s", tc.expect, got)
+			}
+		})
+	}
+
+	e := &Error{Kind: KindRuntime, Msg: "no position"}
+	if got := e.Format("1 == 1"); got != "no position" {
+		t.Fatalf("unexpected format %q", got)
+	}
+}
+
+func Test_error_unwrap(t *testing.T) {
+	errFailed := errors.New("failed")
+	program, err := Compile(`fail()`, WithFunc("fail", func() error { return errFailed }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = program.Eval(nil)
+
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindRuntime || e.Line != 1 || e.Column != 6 {
+		t.Fatalf("want runtime *Error at the call, got %v", err)
+	}
+	var callErr *CallError
+	if !errors.As(err, &callErr) || callErr.Name != "fail" {
+		t.Fatalf("want CallError, got %v", err)
+	}
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("want %v, got %v", errFailed, err)
+	}
+}
+
+func Test_error_list(t *testing.T) {
+	src := "x == # and (1 + ) or y > and z ) 1"
+	_, err := Compile(src)
+
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("want ErrorList, got %T %v", err, err)
+	}
+	expect := []string{
+		"[line 1:6] unexpected character #",
+		"[line 1:8] at 'and': Expect expression.",
+		"[line 1:17] at ')': Expect expression.",
+		"[line 1:26] at 'and': Expect expression.",
+		"[line 1:32] at ')': Expect end of expression.",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("want %d errors, got %d: %v", len(expect), len(errs), errs.Unwrap())
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Fatalf("want %q, got %q", expect[i], e.Error())
+		}
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e != errs[0] {
+		t.Fatalf("want the first error, got %v", e)
+	}
+
+	// the errors inside parentheses and brackets are reported one by one
+	nested := map[string][]string{
+		"a and (b c) or (d e) or f(1 2)": {
+			"[line 1:10] at 'c': Expect ')' after expression.",
+			"[line 1:19] at 'e': Expect ')' after expression.",
+			"[line 1:29] at '2': Expect ')' after arguments.",
+		},
+		"f(a b, c) or [1 2, x[3 4]]": {
+			"[line 1:5] at 'b': Expect ')' after arguments.",
+			"[line 1:17] at '2': expect ']'",
+			"[line 1:24] at '4': Expect ']' after index.",
+		},
+		"a ? b c : (d e)": {
+			"[line 1:7] at 'c': Expect ':' after then branch of conditional expression.",
+			"[line 1:14] at 'e': Expect ')' after expression.",
+		},
+		"a) or f(1 2)": {
+			"[line 1:2] at ')': Expect end of expression.",
+			"[line 1:11] at '2': Expect ')' after arguments.",
+		},
+		// a construct missing its closing token is reported once, not by
+		// each enclosing one
+		"((((": {"[line 1:5] at end: Expect expression."},
+		"x[":   {"[line 1:3] at end: Expect expression."},
+		")":    {"[line 1:1] at ')': Expect expression."},
+		"f(x[": {"[line 1:5] at end: Expect expression."},
+	}
+	for src, expect := range nested {
+		_, err := Compile(src)
+		var errs ErrorList
+		if !errors.As(err, &errs) {
+			t.Fatalf("%s: want ErrorList, got %T %v", src, err, err)
+		}
+		got := make([]string, 0, len(errs))
+		for _, e := range errs {
+			got = append(got, e.Error())
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%s: want %q, got %q", src, expect, got)
+		}
+	}
+
+	partial := map[string]string{
+		"1 + ":               "(bad)",
+		"a and (b or ) or c": "(or (and a (group (or b (bad)))) c)",
+		"f(1 +, 2) and x":    "(and (f (bad) 2) x)",
+		"(a b) or c":         "(or (group (bad)) c)",
+		"f(a b, c)":          "(f (bad) c)",
+		"a ? b":              "(bad)",
+		"a ? b c : d":        "(? a (bad) d)",
+		"x and f(1":          "(and x (bad))",
+		"a) or b":            "a",
+	}
+	for src, expect := range partial {
+		tokens, err := NewScanner(src).ScanTokens()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expr, err := NewParser(tokens).Parse()
+		if err == nil {
+			t.Fatalf("%s: want error", src)
+		}
+		if got := (&AstPrinter{}).Print(expr); got != expect {
+			t.Fatalf("%s: want partial expression %s, got %s", src, expect, got)
+		}
+	}
+}
+
+func Test_span(t *testing.T) {
+	src := "f(\"肤质\")[0] == 'a' or\n  -x > (1 + 2) and [1, y] contains z?.w ? !ok : nil ?? 3"
+	e, err := toExpr(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	Inspect(e, func(e Expr) bool {
+		if e != nil {
+			s := e.Span()
+			got = append(got, fmt.Sprintf("%d:%d %s", s.Line, s.Column, src[s.Start:s.End]))
+		}
+		return true
+	})
+	expect := []string{
+		"1:1 " + src,
+		"1:1 f(\"肤质\")[0] == 'a' or\n  -x > (1 + 2) and [1, y] contains z?.w",
+		"1:1 f(\"肤质\")[0] == 'a'",
+		"1:1 f(\"肤质\")[0]",
+		"1:1 f(\"肤质\")",
+		"1:1 f",
+		"1:3 \"肤质\"",
+		"1:9 0",
+		"1:15 'a'",
+		"2:3 -x > (1 + 2) and [1, y] contains z?.w",
+		"2:3 -x > (1 + 2)",
+		"2:3 -x",
+		"2:4 x",
+		"2:8 (1 + 2)",
+		"2:9 1 + 2",
+		"2:9 1",
+		"2:13 2",
+		"2:20 [1, y] contains z?.w",
+		"2:20 [1, y]",
+		"2:21 1",
+		"2:24 y",
+		"2:36 z?.w",
+		"2:36 z",
+		"2:43 !ok",
+		"2:44 ok",
+		"2:49 nil ?? 3",
+		"2:49 nil",
+		"2:56 3",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("want spans\n%s\ngot\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+
+	// runtime errors point at the offending operand
+	program, err := Compile("a or b or\n  (c + 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = program.Eval(map[string]interface{}{"a": false, "b": false, "c": 1})
+	var re *Error
+	if !errors.As(err, &re) || re.Line != 2 || re.Column != 3 || re.Start != 12 || re.End != 19 {
+		t.Fatalf("want error at the third operand, got %v", err)
+	}
+}
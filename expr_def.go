@@ -7,7 +7,11 @@ import "reflect"
 type Expr interface {
 	AcceptStr(visitor ExprVisitorStr) string
 	AcceptObj(visitor ExprVisitorObj) (interface{}, error)
-} 
+	children() []Expr
+	withChildren(children []Expr) Expr
+	Span() Span
+	setSpan(span Span)
+}
 
 type ExprVisitorStr interface{
 	VisitExprBinaryStr(binary *ExprBinary) string
@@ -18,6 +22,10 @@ type ExprVisitorStr interface{
 	VisitExprUnaryStr(unary *ExprUnary) string
 	VisitExprArrayStr(array *ExprArray) string
 	VisitExprVariableStr(variable *ExprVariable) string
+	VisitExprGetStr(get *ExprGet) string
+	VisitExprIndexStr(index *ExprIndex) string
+	VisitExprBadStr(bad *ExprBad) string
+	VisitExprConditionalStr(conditional *ExprConditional) string
 }
 
 type ExprVisitorObj interface{
@@ -29,12 +37,17 @@ type ExprVisitorObj interface{
 	VisitExprUnaryObj(unary *ExprUnary) (interface{}, error)
 	VisitExprArrayObj(array *ExprArray) (interface{}, error)
 	VisitExprVariableObj(variable *ExprVariable) (interface{}, error)
+	VisitExprGetObj(get *ExprGet) (interface{}, error)
+	VisitExprIndexObj(index *ExprIndex) (interface{}, error)
+	VisitExprBadObj(bad *ExprBad) (interface{}, error)
+	VisitExprConditionalObj(conditional *ExprConditional) (interface{}, error)
 }
 
 type ExprBinary struct {
 	left Expr
 	operator *Token
 	right Expr
+	span Span
 }
 
 func NewExprBinary(left Expr, operator *Token, right Expr) Expr {
@@ -53,10 +66,42 @@ func (e *ExprBinary) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprBinaryObj(e)
 }
 
+func (e *ExprBinary) Left() Expr {
+	return e.left
+}
+
+func (e *ExprBinary) Operator() *Token {
+	return e.operator
+}
+
+func (e *ExprBinary) Right() Expr {
+	return e.right
+}
+
+func (e *ExprBinary) Span() Span {
+	return e.span
+}
+
+func (e *ExprBinary) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprBinary) children() []Expr {
+	return []Expr{e.left, e.right}
+}
+
+func (e *ExprBinary) withChildren(children []Expr) Expr {
+	t := *e
+	t.left = children[0]
+	t.right = children[1]
+	return &t
+}
+
 type ExprCall struct {
 	callee Expr
 	paren *Token
 	arguments []Expr
+	span Span
 }
 
 func NewExprCall(callee Expr, paren *Token, arguments []Expr) Expr {
@@ -75,8 +120,40 @@ func (e *ExprCall) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprCallObj(e)
 }
 
+func (e *ExprCall) Callee() Expr {
+	return e.callee
+}
+
+func (e *ExprCall) Paren() *Token {
+	return e.paren
+}
+
+func (e *ExprCall) Arguments() []Expr {
+	return e.arguments
+}
+
+func (e *ExprCall) Span() Span {
+	return e.span
+}
+
+func (e *ExprCall) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprCall) children() []Expr {
+	return append([]Expr{e.callee}, e.arguments...)
+}
+
+func (e *ExprCall) withChildren(children []Expr) Expr {
+	t := *e
+	t.callee = children[0]
+	t.arguments = children[1:1+len(e.arguments)]
+	return &t
+}
+
 type ExprGrouping struct {
 	expression Expr
+	span Span
 }
 
 func NewExprGrouping(expression Expr) Expr {
@@ -93,9 +170,32 @@ func (e *ExprGrouping) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprGroupingObj(e)
 }
 
+func (e *ExprGrouping) Expression() Expr {
+	return e.expression
+}
+
+func (e *ExprGrouping) Span() Span {
+	return e.span
+}
+
+func (e *ExprGrouping) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprGrouping) children() []Expr {
+	return []Expr{e.expression}
+}
+
+func (e *ExprGrouping) withChildren(children []Expr) Expr {
+	t := *e
+	t.expression = children[0]
+	return &t
+}
+
 type ExprLiteral struct {
 	value interface{}
 	rtype reflect.Kind
+	span Span
 }
 
 func NewExprLiteral(value interface{}, rtype reflect.Kind) Expr {
@@ -113,10 +213,35 @@ func (e *ExprLiteral) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprLiteralObj(e)
 }
 
+func (e *ExprLiteral) Value() interface{} {
+	return e.value
+}
+
+func (e *ExprLiteral) Rtype() reflect.Kind {
+	return e.rtype
+}
+
+func (e *ExprLiteral) Span() Span {
+	return e.span
+}
+
+func (e *ExprLiteral) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprLiteral) children() []Expr {
+	return nil
+}
+
+func (e *ExprLiteral) withChildren(children []Expr) Expr {
+	return e
+}
+
 type ExprLogical struct {
 	left Expr
 	operator *Token
 	right Expr
+	span Span
 }
 
 func NewExprLogical(left Expr, operator *Token, right Expr) Expr {
@@ -135,9 +260,41 @@ func (e *ExprLogical) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprLogicalObj(e)
 }
 
+func (e *ExprLogical) Left() Expr {
+	return e.left
+}
+
+func (e *ExprLogical) Operator() *Token {
+	return e.operator
+}
+
+func (e *ExprLogical) Right() Expr {
+	return e.right
+}
+
+func (e *ExprLogical) Span() Span {
+	return e.span
+}
+
+func (e *ExprLogical) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprLogical) children() []Expr {
+	return []Expr{e.left, e.right}
+}
+
+func (e *ExprLogical) withChildren(children []Expr) Expr {
+	t := *e
+	t.left = children[0]
+	t.right = children[1]
+	return &t
+}
+
 type ExprUnary struct {
 	operator *Token
 	right Expr
+	span Span
 }
 
 func NewExprUnary(operator *Token, right Expr) Expr {
@@ -155,9 +312,36 @@ func (e *ExprUnary) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprUnaryObj(e)
 }
 
+func (e *ExprUnary) Operator() *Token {
+	return e.operator
+}
+
+func (e *ExprUnary) Right() Expr {
+	return e.right
+}
+
+func (e *ExprUnary) Span() Span {
+	return e.span
+}
+
+func (e *ExprUnary) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprUnary) children() []Expr {
+	return []Expr{e.right}
+}
+
+func (e *ExprUnary) withChildren(children []Expr) Expr {
+	t := *e
+	t.right = children[0]
+	return &t
+}
+
 type ExprArray struct {
 	bracket *Token
 	items []Expr
+	span Span
 }
 
 func NewExprArray(bracket *Token, items []Expr) Expr {
@@ -175,8 +359,35 @@ func (e *ExprArray) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprArrayObj(e)
 }
 
+func (e *ExprArray) Bracket() *Token {
+	return e.bracket
+}
+
+func (e *ExprArray) Items() []Expr {
+	return e.items
+}
+
+func (e *ExprArray) Span() Span {
+	return e.span
+}
+
+func (e *ExprArray) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprArray) children() []Expr {
+	return append([]Expr{}, e.items...)
+}
+
+func (e *ExprArray) withChildren(children []Expr) Expr {
+	t := *e
+	t.items = children[0:0+len(e.items)]
+	return &t
+}
+
 type ExprVariable struct {
 	name *Token
+	span Span
 }
 
 func NewExprVariable(name *Token) Expr {
@@ -193,3 +404,249 @@ func (e *ExprVariable) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
 	return visitor.VisitExprVariableObj(e)
 }
 
+func (e *ExprVariable) Name() *Token {
+	return e.name
+}
+
+func (e *ExprVariable) Span() Span {
+	return e.span
+}
+
+func (e *ExprVariable) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprVariable) children() []Expr {
+	return nil
+}
+
+func (e *ExprVariable) withChildren(children []Expr) Expr {
+	return e
+}
+
+type ExprGet struct {
+	object Expr
+	name *Token
+	optional bool
+	span Span
+}
+
+func NewExprGet(object Expr, name *Token, optional bool) Expr {
+	t := &ExprGet{}
+	t.object = object
+	t.name = name
+	t.optional = optional
+	return t
+}
+
+func (e *ExprGet) AcceptStr(visitor ExprVisitorStr) string {
+	return visitor.VisitExprGetStr(e)
+}
+
+func (e *ExprGet) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
+	return visitor.VisitExprGetObj(e)
+}
+
+func (e *ExprGet) Object() Expr {
+	return e.object
+}
+
+func (e *ExprGet) Name() *Token {
+	return e.name
+}
+
+func (e *ExprGet) Optional() bool {
+	return e.optional
+}
+
+func (e *ExprGet) Span() Span {
+	return e.span
+}
+
+func (e *ExprGet) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprGet) children() []Expr {
+	return []Expr{e.object}
+}
+
+func (e *ExprGet) withChildren(children []Expr) Expr {
+	t := *e
+	t.object = children[0]
+	return &t
+}
+
+type ExprIndex struct {
+	object Expr
+	bracket *Token
+	index Expr
+	colon *Token
+	end Expr
+	span Span
+}
+
+func NewExprIndex(object Expr, bracket *Token, index Expr, colon *Token, end Expr) Expr {
+	t := &ExprIndex{}
+	t.object = object
+	t.bracket = bracket
+	t.index = index
+	t.colon = colon
+	t.end = end
+	return t
+}
+
+func (e *ExprIndex) AcceptStr(visitor ExprVisitorStr) string {
+	return visitor.VisitExprIndexStr(e)
+}
+
+func (e *ExprIndex) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
+	return visitor.VisitExprIndexObj(e)
+}
+
+func (e *ExprIndex) Object() Expr {
+	return e.object
+}
+
+func (e *ExprIndex) Bracket() *Token {
+	return e.bracket
+}
+
+func (e *ExprIndex) Index() Expr {
+	return e.index
+}
+
+func (e *ExprIndex) Colon() *Token {
+	return e.colon
+}
+
+func (e *ExprIndex) End() Expr {
+	return e.end
+}
+
+func (e *ExprIndex) Span() Span {
+	return e.span
+}
+
+func (e *ExprIndex) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprIndex) children() []Expr {
+	return []Expr{e.object, e.index, e.end}
+}
+
+func (e *ExprIndex) withChildren(children []Expr) Expr {
+	t := *e
+	t.object = children[0]
+	t.index = children[1]
+	t.end = children[2]
+	return &t
+}
+
+type ExprBad struct {
+	from *Token
+	to *Token
+	span Span
+}
+
+func NewExprBad(from *Token, to *Token) Expr {
+	t := &ExprBad{}
+	t.from = from
+	t.to = to
+	return t
+}
+
+func (e *ExprBad) AcceptStr(visitor ExprVisitorStr) string {
+	return visitor.VisitExprBadStr(e)
+}
+
+func (e *ExprBad) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
+	return visitor.VisitExprBadObj(e)
+}
+
+func (e *ExprBad) From() *Token {
+	return e.from
+}
+
+func (e *ExprBad) To() *Token {
+	return e.to
+}
+
+func (e *ExprBad) Span() Span {
+	return e.span
+}
+
+func (e *ExprBad) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprBad) children() []Expr {
+	return nil
+}
+
+func (e *ExprBad) withChildren(children []Expr) Expr {
+	return e
+}
+
+type ExprConditional struct {
+	condition Expr
+	question *Token
+	then Expr
+	otherwise Expr
+	span Span
+}
+
+func NewExprConditional(condition Expr, question *Token, then Expr, otherwise Expr) Expr {
+	t := &ExprConditional{}
+	t.condition = condition
+	t.question = question
+	t.then = then
+	t.otherwise = otherwise
+	return t
+}
+
+func (e *ExprConditional) AcceptStr(visitor ExprVisitorStr) string {
+	return visitor.VisitExprConditionalStr(e)
+}
+
+func (e *ExprConditional) AcceptObj(visitor ExprVisitorObj) (interface{}, error) {
+	return visitor.VisitExprConditionalObj(e)
+}
+
+func (e *ExprConditional) Condition() Expr {
+	return e.condition
+}
+
+func (e *ExprConditional) Question() *Token {
+	return e.question
+}
+
+func (e *ExprConditional) Then() Expr {
+	return e.then
+}
+
+func (e *ExprConditional) Otherwise() Expr {
+	return e.otherwise
+}
+
+func (e *ExprConditional) Span() Span {
+	return e.span
+}
+
+func (e *ExprConditional) setSpan(span Span) {
+	e.span = span
+}
+
+func (e *ExprConditional) children() []Expr {
+	return []Expr{e.condition, e.then, e.otherwise}
+}
+
+func (e *ExprConditional) withChildren(children []Expr) Expr {
+	t := *e
+	t.condition = children[0]
+	t.then = children[1]
+	t.otherwise = children[2]
+	return &t
+}
+
@@ -0,0 +1,467 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// formatWidth is the width beyond which the "and" and "or" chains are broken
+// into several lines.
+const formatWidth = 80
+
+// Format returns the source of expr in canonical style: the operators in
+// their default spelling, surrounded by single spaces, the parentheses only
+// where the precedence requires them, the strings double-quoted, and the
+// long "and" and "or" chains broken before each operator, one operand per
+// line.
+//
+// Parsing the result gives back expr, except for the redundant groupings.
+func Format(expr Expr) string {
+	f := &formatter{width: formatWidth}
+	return f.print(expr)
+}
+
+// FormatSource formats the expression in src like Format, keeping its
+// comments on their own lines: a comment is moved before the next operand of
+// a broken "and" or "or" chain, or after the expression. The result ends
+// with a newline.
+//
+// Only the comments before the expression and between the operands of "and"
+// and "or" stay in place. The others, such as a comment between the
+// arguments of a call, are moved after the expression, in source order,
+// since the formatter never breaks the lines inside an operand.
+func FormatSource(src string, opts ...ScannerOption) (string, error) {
+	opts = append(opts[:len(opts):len(opts)], WithComments())
+	tokens, err := NewScanner(src, opts...).ScanTokens()
+	if err != nil {
+		return "", err
+	}
+	expr, err := NewParser(tokens).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	f := &formatter{width: formatWidth}
+	last := tokens[0]
+	for _, token := range tokens {
+		switch token.typ {
+		case TokenComment:
+			f.comments = append(f.comments, token)
+		case TokenEOF:
+		default:
+			last = token
+		}
+	}
+
+	var builder strings.Builder
+	start := expr.Span().Start
+	for len(f.comments) > 0 && f.comments[0].offset < start {
+		builder.WriteString(f.comments[0].lexeme)
+		builder.WriteString("\n")
+		f.comments = f.comments[1:]
+	}
+	builder.WriteString(f.print(expr))
+	for i, comment := range f.comments {
+		if i == 0 && comment.line == last.line && comment.offset > last.offset {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(comment.lexeme)
+	}
+	builder.WriteString("\n")
+
+	return builder.String(), nil
+}
+
+// formatter is a visitor printing the canonical source of an expression.
+type formatter struct {
+	// width is the maximum width of a line, 0 meaning unlimited.
+	width int
+	// indent is the number of tabs of the lines broken in the current chain.
+	indent int
+	// comments are the comments not printed yet, in source order.
+	comments []*Token
+}
+
+var _ ExprVisitorStr = (*formatter)(nil)
+
+func (f *formatter) print(expr Expr) string {
+	return expr.AcceptStr(f)
+}
+
+// Operator precedences, from the lowest to the highest, see the grammar in
+// parser.go.
+const (
+	precConditional = iota + 1
+	precOr
+	precAnd
+	precEquality
+	precMembership
+	precComparison
+	precCoalesce
+	precTerm
+	precFactor
+	precUnary
+	precCall
+	precPrimary
+)
+
+var binaryPrecedence = map[TokenType]int{
+	TokenEqualEqual:       precEquality,
+	TokenBangEqual:        precEquality,
+	TokenIn:               precMembership,
+	TokenNotIn:            precMembership,
+	TokenContains:         precMembership,
+	TokenContainsAny:      precMembership,
+	TokenContainsAll:      precMembership,
+	TokenSubsetOf:         precMembership,
+	TokenIntersects:       precMembership,
+	TokenGreater:          precComparison,
+	TokenGreaterEqual:     precComparison,
+	TokenLess:             precComparison,
+	TokenLessEqual:        precComparison,
+	TokenQuestionQuestion: precCoalesce,
+	TokenMinus:            precTerm,
+	TokenPlus:             precTerm,
+	TokenSlash:            precFactor,
+	TokenStar:             precFactor,
+	TokenPercent:          precFactor,
+	TokenOr:               precOr,
+	TokenAnd:              precAnd,
+}
+
+// canonical are the default spellings of the operators, which may have
+// been scanned from an alias.
+var canonical = map[TokenType]string{
+	TokenBang:             "!",
+	TokenMinus:            "-",
+	TokenEqualEqual:       "==",
+	TokenBangEqual:        "!=",
+	TokenIn:               "in",
+	TokenNotIn:            "not in",
+	TokenContains:         "contains",
+	TokenContainsAny:      "contains_any",
+	TokenContainsAll:      "contains_all",
+	TokenSubsetOf:         "subset_of",
+	TokenIntersects:       "intersects",
+	TokenGreater:          ">",
+	TokenGreaterEqual:     ">=",
+	TokenLess:             "<",
+	TokenLessEqual:        "<=",
+	TokenQuestionQuestion: "??",
+	TokenPlus:             "+",
+	TokenSlash:            "/",
+	TokenStar:             "*",
+	TokenPercent:          "%",
+	TokenOr:               "or",
+	TokenAnd:              "and",
+}
+
+// precedence returns the precedence of the operator of expr.
+func precedence(expr Expr) int {
+	switch e := expr.(type) {
+	case *ExprConditional:
+		return precConditional
+	case *ExprLogical:
+		return binaryPrecedence[e.operator.typ]
+	case *ExprBinary:
+		return binaryPrecedence[e.operator.typ]
+	case *ExprUnary:
+		return precUnary
+	case *ExprCall, *ExprGet, *ExprIndex:
+		return precCall
+	case *ExprGrouping:
+		return precedence(e.expression)
+	case *ExprLiteral:
+		// a negative number is printed with a unary minus
+		if n, ok := toNumber(e.value); ok && (n.isFloat && math.Signbit(n.f) || !n.isFloat && n.i < 0) {
+			return precUnary
+		}
+		return precPrimary
+	default:
+		return precPrimary
+	}
+}
+
+// operand prints expr, in parentheses if its precedence is lower than min.
+func (f *formatter) operand(expr Expr, min int) string {
+	if precedence(expr) < min {
+		return "(" + f.print(expr) + ")"
+	}
+	return f.print(expr)
+}
+
+func (f *formatter) list(exprs []Expr) string {
+	items := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		items = append(items, f.print(expr))
+	}
+	return strings.Join(items, ", ")
+}
+
+func (f *formatter) VisitExprBinaryStr(expr *ExprBinary) string {
+	prec := binaryPrecedence[expr.operator.typ]
+	return f.operand(expr.left, prec) + " " + canonical[expr.operator.typ] + " " + f.operand(expr.right, prec+1)
+}
+
+func (f *formatter) VisitExprLogicalStr(expr *ExprLogical) string {
+	prec := binaryPrecedence[expr.operator.typ]
+	if expr.operator.typ == TokenQuestionQuestion {
+		// right associative
+		return f.operand(expr.left, prec+1) + " ?? " + f.operand(expr.right, prec)
+	}
+	return f.chain(expr, prec)
+}
+
+// chain prints a chain of "and" or "or", on several lines if it is too long
+// or if there are comments to print between its operands.
+func (f *formatter) chain(expr *ExprLogical, prec int) string {
+	operands := chainOperands(expr)
+	operator := canonical[expr.operator.typ]
+
+	flat := &formatter{}
+	parts := make([]string, 0, len(operands))
+	for i, operand := range operands {
+		min := prec + 1
+		if i == 0 {
+			min = prec
+		}
+		parts = append(parts, flat.operand(operand, min))
+	}
+	line := strings.Join(parts, " "+operator+" ")
+
+	broken := f.width > 0 && f.indent*4+textWidth(line) > f.width || f.chainComments(expr)
+	if !broken {
+		// print the comments inside the operands later
+		return line
+	}
+
+	var builder strings.Builder
+	f.indent++
+	indent := "\n" + strings.Repeat("\t", f.indent)
+	for i, operand := range operands {
+		if i == 0 {
+			builder.WriteString(f.operand(operand, prec))
+			continue
+		}
+		builder.WriteString(indent)
+		for _, comment := range f.between(operands[i-1], operand, true) {
+			builder.WriteString(comment.lexeme)
+			builder.WriteString(indent)
+		}
+		builder.WriteString(operator + " " + f.operand(operand, prec+1))
+	}
+	f.indent--
+
+	return builder.String()
+}
+
+// chainOperands returns the operands of a chain of the operator of expr,
+// which is left associative.
+func chainOperands(expr *ExprLogical) []Expr {
+	left := expr.left
+	for {
+		grouping, ok := left.(*ExprGrouping)
+		if !ok {
+			break
+		}
+		left = grouping.expression
+	}
+
+	if l, ok := left.(*ExprLogical); ok && l.operator.typ == expr.operator.typ {
+		return append(chainOperands(l), expr.right)
+	}
+	return []Expr{expr.left, expr.right}
+}
+
+// chainComments reports whether there are comments to print between the
+// operands of the "and" or "or" chain expr, or of the chains nested in its
+// operands.
+func (f *formatter) chainComments(expr Expr) bool {
+	for {
+		grouping, ok := expr.(*ExprGrouping)
+		if !ok {
+			break
+		}
+		expr = grouping.expression
+	}
+	chain, ok := expr.(*ExprLogical)
+	if !ok || chain.operator.typ == TokenQuestionQuestion {
+		return false
+	}
+
+	operands := chainOperands(chain)
+	for i, operand := range operands {
+		if i > 0 && len(f.between(operands[i-1], operand, false)) > 0 || f.chainComments(operand) {
+			return true
+		}
+	}
+	return false
+}
+
+// between returns the comments not printed yet between the operands prev
+// and next, removing them from the comments to print if take is set.
+func (f *formatter) between(prev, next Expr, take bool) []*Token {
+	if !prev.Span().IsValid() || !next.Span().IsValid() {
+		return nil
+	}
+
+	var found []*Token
+	rest := f.comments[:0:0]
+	for _, comment := range f.comments {
+		if comment.offset >= prev.Span().End && comment.offset < next.Span().Start {
+			found = append(found, comment)
+		} else {
+			rest = append(rest, comment)
+		}
+	}
+	if take {
+		f.comments = rest
+	}
+	return found
+}
+
+func (f *formatter) VisitExprConditionalStr(expr *ExprConditional) string {
+	return f.operand(expr.condition, precConditional+1) + " ? " + f.print(expr.then) + " : " +
+		f.operand(expr.otherwise, precConditional)
+}
+
+func (f *formatter) VisitExprUnaryStr(expr *ExprUnary) string {
+	return canonical[expr.operator.typ] + f.operand(expr.right, precUnary)
+}
+
+func (f *formatter) VisitExprCallStr(expr *ExprCall) string {
+	return f.object(expr.callee) + "(" + f.list(expr.arguments) + ")"
+}
+
+// object prints the object of a member access or index, or the callee of a
+// call. The parentheses around an optional chain are kept since they stop
+// its short-circuit: (a?.b).c fails if a is nil, a?.b.c does not.
+func (f *formatter) object(expr Expr) string {
+	if grouping, ok := expr.(*ExprGrouping); ok && isOptionalChain(grouping.expression) {
+		return "(" + f.print(expr) + ")"
+	}
+	return f.operand(expr, precCall)
+}
+
+// isOptionalChain reports whether expr is a chain of member accesses,
+// indexes and calls with an optional link.
+func isOptionalChain(expr Expr) bool {
+	switch e := expr.(type) {
+	case *ExprGrouping:
+		return isOptionalChain(e.expression)
+	case *ExprGet:
+		return e.optional || isOptionalChain(e.object)
+	case *ExprIndex:
+		return isOptionalChain(e.object)
+	case *ExprCall:
+		return isOptionalChain(e.callee)
+	default:
+		return false
+	}
+}
+
+func (f *formatter) VisitExprGetStr(expr *ExprGet) string {
+	operator := "."
+	if expr.optional {
+		operator = "?."
+	}
+	return f.object(expr.object) + operator + expr.name.lexeme
+}
+
+func (f *formatter) VisitExprIndexStr(expr *ExprIndex) string {
+	var builder strings.Builder
+	builder.WriteString(f.object(expr.object))
+	builder.WriteString("[")
+	if expr.index != nil {
+		builder.WriteString(f.print(expr.index))
+	}
+	if expr.colon != nil {
+		builder.WriteString(":")
+		if expr.end != nil {
+			builder.WriteString(f.print(expr.end))
+		}
+	}
+	builder.WriteString("]")
+	return builder.String()
+}
+
+func (f *formatter) VisitExprGroupingStr(expr *ExprGrouping) string {
+	// the parent adds the parentheses if needed
+	return f.print(expr.expression)
+}
+
+func (f *formatter) VisitExprArrayStr(expr *ExprArray) string {
+	return "[" + f.list(expr.items) + "]"
+}
+
+func (f *formatter) VisitExprVariableStr(expr *ExprVariable) string {
+	return expr.name.lexeme
+}
+
+func (f *formatter) VisitExprLiteralStr(expr *ExprLiteral) string {
+	switch v := expr.value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return quote(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			// keep it a float
+			s += ".0"
+		}
+		return s
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (f *formatter) VisitExprBadStr(expr *ExprBad) string {
+	return "<invalid>"
+}
+
+// quote returns s double-quoted, with the escapes understood by the Scanner.
+func quote(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\r':
+			builder.WriteString(`\r`)
+		default:
+			if unicode.IsPrint(r) || r > 0xFFFF {
+				builder.WriteRune(r)
+			} else {
+				fmt.Fprintf(&builder, `\u%04x`, r)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+// textWidth returns the display width of s.
+func textWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
@@ -0,0 +1,220 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_format(t *testing.T) {
+	testCases := []struct {
+		src  string
+		want string
+	}{
+		{`1+2*3`, `1 + 2 * 3`},
+		{`(1+2)*3`, `(1 + 2) * 3`},
+		{`((a))`, `a`},
+		{`1-(2-3)`, `1 - (2 - 3)`},
+		{`(1-2)-3`, `1 - 2 - 3`},
+		{`a ?? (b ?? c)`, `a ?? b ?? c`},
+		{`(a ?? b) ?? c`, `(a ?? b) ?? c`},
+		{`(a ?? b) + 1`, `(a ?? b) + 1`},
+		{`-(1+2)`, `-(1 + 2)`},
+		{`- - a`, `--a`},
+		{`!(a and b)`, `!(a and b)`},
+		{`(-a).b`, `(-a).b`},
+		{`(a+b)[1:]`, `(a + b)[1:]`},
+		{`a?.b.c[ 0 ]( 1,2 )`, `a?.b.c[0](1, 2)`},
+		{`a[:2]`, `a[:2]`},
+		{`(a?.b).c`, `(a?.b).c`},
+		{`((a?.b.c))[0](1)`, `(a?.b.c)[0](1)`},
+		{`(a.b).c`, `a.b.c`},
+		{`(a?.b)?.c`, `(a?.b)?.c`},
+		{`[ ]`, `[]`},
+		{`(a or b) and c`, `(a or b) and c`},
+		{`a or (b and c)`, `a or b and c`},
+		{`a and (b and c)`, `a and (b and c)`},
+		{`(a and b) and c`, `a and b and c`},
+		{`(a ? b : c) ? d : e`, `(a ? b : c) ? d : e`},
+		{`a ? (b ? c : d) : (e ? f : g)`, `a ? b ? c : d : e ? f : g`},
+		{`(a ? b : c) or d`, `(a ? b : c) or d`},
+		{`x && y || !z`, `x and y or !z`},
+		{`a <> b`, `a != b`},
+		{`a not  in [1]`, `a not in [1]`},
+		{`'say "hi"'`, `"say \"hi\""`},
+		{"`a\\b\n`", `"a\\b\n"`},
+		{`"\u0001é"`, `"\u0001é"`},
+		{`1.0 + 2.50 + 1e3 + 0x1F + 1_000`, `1.0 + 2.5 + 1000.0 + 31 + 1000`},
+		{`nil ?? true`, `nil ?? true`},
+		{
+			`ner_entities("肤质") contains "干性" and ner_entities("功效") contains "补水" or user.tags[0] == "vip"`,
+			"ner_entities(\"肤质\") contains \"干性\"\n" +
+				"\t\tand ner_entities(\"功效\") contains \"补水\"\n" +
+				"\tor user.tags[0] == \"vip\"",
+		},
+		{
+			`user.level >= 3 and user.country in ["CN", "JP", "KR"] and user.tags contains_any ["vip", "svip"] and !user.banned`,
+			"user.level >= 3\n" +
+				"\tand user.country in [\"CN\", \"JP\", \"KR\"]\n" +
+				"\tand user.tags contains_any [\"vip\", \"svip\"]\n" +
+				"\tand !user.banned",
+		},
+	}
+
+	for _, tc := range testCases {
+		e, err := toExpr(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.src, err)
+		}
+		if got := Format(e); got != tc.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+// Test_format_round_trip checks that formatted source parses back to the same
+// AST without the groupings, that it evaluates to the same result with a nil
+// a, and that formatting it again changes nothing.
+func Test_format_round_trip(t *testing.T) {
+	srcs := []string{
+		`1 + 2 * 3 - 4 / (5 % 6)`,
+		`((1 - 2) - (3 - 4)) * -(-5)`,
+		`a ?? (b ?? c) ?? (d ?? e)`,
+		`(a ?? b) + c ?? d`,
+		`!(!a and b) or (c or d) and (e or f)`,
+		`(a ? b : c) ? (d ? e : f) : g ? h : i`,
+		`(a == b) == (c != d)`,
+		`(a in b) in c contains (d subset_of e)`,
+		`(a < b) > (c <= d)`,
+		`x.y?.z[1:2][:n]([a, b][0], [c, (d)])`,
+		`"tab\t" + 'quote"' + "\\" + "\r\n" + "中文"`,
+		`0.5 + 1e-7 + 1e21 + 123456789012 + -3`,
+		`f(a) and g(b) and h(c) and i(d) and j(e) and k(f) and l(g) and m(h) and n(i) or o`,
+		`(a and b or c and d and e and fffffffffffffffffffff and ggggggggggggggggggggg and hhhhhhhhhhhhhhhhhh) or x`,
+		`f((a and b and cccccccccccccccccccccccccccccc and ddddddddddddddddddddddddddd and eeeeeeeeeeeeeeeeeeeeee))`,
+		`(a?.b).c`,
+		`(a?.b)[0]`,
+		`(a?.b?.c).d ?? 1`,
+		`a?.b.c ?? 1`,
+	}
+
+	p := NewInterpreter()
+	p.Environment.Define("a", nil)
+	eval := func(e Expr) string {
+		res, err := p.Interpret(e)
+		if err != nil {
+			return "error"
+		}
+		return fmt.Sprintf("%T %v", res, res)
+	}
+
+	printer := &AstPrinter{}
+	strip := func(e Expr) Expr {
+		return Rewrite(e, func(e Expr) Expr {
+			if g, ok := e.(*ExprGrouping); ok {
+				return g.Expression()
+			}
+			return e
+		})
+	}
+
+	for _, src := range srcs {
+		e, err := toExpr(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+		formatted := Format(e)
+		again, err := toExpr(formatted)
+		if err != nil {
+			t.Fatalf("%s: formatted %q: %s", src, formatted, err)
+		}
+		if got, want := printer.Print(strip(again)), printer.Print(strip(e)); got != want {
+			t.Errorf("%s: formatted %q\ngot  %s\nwant %s", src, formatted, got, want)
+		}
+		if got, want := eval(again), eval(e); got != want {
+			t.Errorf("%s: formatted %q evaluates to %s, want %s", src, formatted, got, want)
+		}
+		if twice := Format(again); twice != formatted {
+			t.Errorf("%s: not idempotent\n%s\n%s", src, formatted, twice)
+		}
+	}
+}
+
+func Test_format_source(t *testing.T) {
+	testCases := []struct {
+		src  string
+		opts []ScannerOption
+		want string
+		err  string
+	}{
+		{src: `a+b`, want: "a + b\n"},
+		{src: "// rule\na+b // sum\n", want: "// rule\na + b // sum\n"},
+		{src: "a+b /* x */ /* y */", want: "a + b /* x */\n/* y */\n"},
+		// the comments inside calls, lists and operands other than those of
+		// "and" and "or" are moved after the expression
+		{src: "f(a, // first\n b)", want: "f(a, b)\n// first\n"},
+		{src: "f(1, // arg\n 2) and x", want: "f(1, 2) and x\n// arg\n"},
+		{src: "a + // one\n b > /* two */ c", want: "a + b > c\n// one\n/* two */\n"},
+		{src: "a and // c1\nb or c", want: "a\n\t\t// c1\n\t\tand b\n\tor c\n"},
+		{src: "(a or // c1\nb) and c", want: "(a\n\t\t// c1\n\t\tor b)\n\tand c\n"},
+		{
+			src:  "a // first\n&& b\n// second\n|| c",
+			want: "a\n\t\t// first\n\t\tand b\n\t// second\n\tor c\n",
+		},
+		{
+			src:  "且(a 且 b)",
+			opts: []ScannerOption{WithKeywords(DefaultKeywords, ChineseKeywords)},
+			err:  "Expect expression.",
+		},
+		{
+			src:  "a 且 非 b 或 x 包含 1",
+			opts: []ScannerOption{WithKeywords(DefaultKeywords, ChineseKeywords)},
+			want: "a and !b or x contains 1\n",
+		},
+		{src: `a +`, err: "Expect expression."},
+	}
+
+	for _, tc := range testCases {
+		got, err := FormatSource(tc.src, tc.opts...)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: unexpected error %v", tc.src, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+		if got != tc.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tc.src, got, tc.want)
+		}
+		if again, err := FormatSource(got, tc.opts...); err != nil || again != got {
+			t.Errorf("%q: not idempotent %q, %v", tc.src, again, err)
+		}
+	}
+}
+
+func Test_format_literal(t *testing.T) {
+	testCases := []struct {
+		value interface{}
+		want  string
+	}{
+		{int64(-3), `-3`},
+		{-0.5, `-0.5`},
+		{2.0, `2.0`},
+		{"a\x7fb", `"a\u007fb"`},
+	}
+
+	for _, tc := range testCases {
+		e := NewExprLiteral(tc.value, reflect.TypeOf(tc.value).Kind())
+		if got := Format(e); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.value, got, tc.want)
+		}
+		// a negative number is an operand of a unary minus
+		get := NewExprGet(e, &Token{typ: TokenIdentifier, lexeme: "x"}, false)
+		if got := Format(get); strings.HasPrefix(tc.want, "-") && got != "("+tc.want+").x" {
+			t.Errorf("%v: got %s", tc.value, got)
+		}
+	}
+}
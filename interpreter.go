@@ -1,45 +1,59 @@
 package expr
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"runtime/debug"
+	"strings"
 )
 
 func Run(src string) (interface{}, error) {
-	var scanner = NewScanner(src)
-	tokens, err := scanner.ScanTokens()
+	program, err := Compile(src)
 	if err != nil {
 		return nil, err
 	}
 
-	parser := NewParser(tokens)
-	expr, err := parser.Parse()
-	if err != nil {
-		return nil, err
-	}
-
-	p := NewInterpreter()
-	return p.Interpret(expr)
+	return program.Eval(nil)
 }
 
+// Interpreter evaluates expressions. It must not be used by several
+// goroutines at once, use Program for that.
 type Interpreter struct {
 	Environment *Environment
+	// Limits bounds the nesting depth, the number of steps and function calls,
+	// and the length of lists returned by functions.
+	Limits Limits
+
+	ctx   context.Context
+	depth int
+	steps int
+	calls int
 }
 
 var _ ExprVisitorObj = (*Interpreter)(nil)
 
 func NewInterpreter() *Interpreter {
 	interpreter := &Interpreter{
-		Environment: &Environment{
-			values: make(map[string]interface{}),
-		},
+		Environment: NewEnvironment(),
 	}
 
 	return interpreter
 }
 
 func (p *Interpreter) Interpret(expr Expr) (res interface{}, err error) {
+	return p.InterpretContext(context.Background(), expr)
+}
+
+// InterpretContext evaluates expr, stopping with ctx.Err() once ctx is done.
+// ctx is checked before visiting each node, and is passed to functions
+// implementing ContextCallable.
+func (p *Interpreter) InterpretContext(ctx context.Context, expr Expr) (res interface{}, err error) {
+	p.ctx, p.depth, p.steps, p.calls = ctx, 0, 0, 0
+	defer func() {
+		p.ctx = nil
+	}()
+
 	defer func() {
 		r := recover()
 		if r == nil {
@@ -50,35 +64,56 @@ func (p *Interpreter) Interpret(expr Expr) (res interface{}, err error) {
 			err = tr
 		default:
 			stackInfo := debug.Stack()
-			err = RuntimeError{msg: fmt.Sprintf("runtime err: %s, stack info: %s", tr, string(stackInfo))}
+			err = runtimeError("runtime err: %s, stack info: %s", tr, string(stackInfo))
 		}
 	}()
 
 	return p.evaluate(expr)
 }
 
+// skipped is the value of the links of a chain of member accesses, indexes
+// and calls after an optional link on a nil value: a?.b.c is nil if a is
+// nil, like in JavaScript.
+type skipped struct{}
+
 func (p *Interpreter) evaluate(expr Expr) (interface{}, error) {
-	return expr.AcceptObj(p)
+	v, err := p.link(expr)
+	if _, ok := v.(skipped); ok {
+		return nil, err
+	}
+	return v, err
 }
 
-const s string = ""
-const f float64 = 0
+// link evaluates the object of a member access, index or call, returning
+// skipped if the rest of the chain is to be skipped.
+func (p *Interpreter) link(expr Expr) (interface{}, error) {
+	if err := p.context().Err(); err != nil {
+		return nil, err
+	}
 
-var strType = reflect.TypeOf(s)
-var numType = reflect.TypeOf(f)
+	p.steps++
+	if err := LimitSteps.check(p.steps, p.Limits.MaxSteps); err != nil {
+		return nil, err
+	}
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+	if err := LimitDepth.check(p.depth, p.Limits.MaxDepth); err != nil {
+		return nil, err
+	}
 
-func (p *Interpreter) VisitExprLiteralObj(expr *ExprLiteral) (interface{}, error) {
-	rv := reflect.ValueOf(expr.value)
+	return expr.AcceptObj(p)
+}
 
-	switch expr.rtype {
-	case reflect.Bool:
-		return expr.value, nil
-	case reflect.String:
-		return rv.Convert(strType).Interface(), nil
-	case reflect.Float64:
-		return rv.Convert(numType).Interface(), nil
+func (p *Interpreter) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
 	}
+	return p.ctx
+}
 
+func (p *Interpreter) VisitExprLiteralObj(expr *ExprLiteral) (interface{}, error) {
 	return expr.value, nil
 }
 
@@ -88,9 +123,16 @@ func (p *Interpreter) VisitExprLogicalObj(expr *ExprLogical) (interface{}, error
 		return false, err
 	}
 
+	if expr.operator.typ == TokenQuestionQuestion {
+		if !isNil(left) {
+			return left, nil
+		}
+		return p.evaluate(expr.right)
+	}
+
 	bLeft, err := isTruthy(left)
 	if err != nil {
-		return false, err
+		return false, locateExpr(err, expr.left, expr.operator)
 	}
 
 	if expr.operator.typ == TokenOr {
@@ -108,7 +150,11 @@ func (p *Interpreter) VisitExprLogicalObj(expr *ExprLogical) (interface{}, error
 		return false, err
 	}
 
-	return isTruthy(right)
+	bRight, err := isTruthy(right)
+	if err != nil {
+		return false, locateExpr(err, expr.right, expr.operator)
+	}
+	return bRight, nil
 }
 
 func (p *Interpreter) VisitExprGroupingObj(expr *ExprGrouping) (interface{}, error) {
@@ -125,17 +171,17 @@ func (p *Interpreter) VisitExprUnaryObj(expr *ExprUnary) (interface{}, error) {
 	case TokenMinus:
 		r, isNumber := toNumber(right)
 		if !isNumber {
-			return nil, RuntimeError{msg: fmt.Sprintf("%+v (%T) is not number", right, right)}
+			return nil, locateExpr(runtimeError("%+v (%T) is not number", right, right), expr.right, expr.operator)
 		}
-		return -r, nil
+		return negate(expr.operator, r)
 	case TokenBang:
 		res, err := isTruthy(right)
 		if err != nil {
-			return nil, err
+			return nil, locateExpr(err, expr.right, expr.operator)
 		}
 		return !res, nil
 	default:
-		return nil, RuntimeError{msg: fmt.Sprintf("unknown operator %s", expr.operator.lexeme)}
+		return nil, RuntimeErrWithToken(expr.operator, "unknown operator")
 	}
 }
 
@@ -143,6 +189,66 @@ func (p *Interpreter) VisitExprVariableObj(expr *ExprVariable) (interface{}, err
 	return p.lookup(expr.name)
 }
 
+func (p *Interpreter) VisitExprGetObj(expr *ExprGet) (interface{}, error) {
+	object, err := p.link(expr.object)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := object.(skipped); ok || expr.optional && isNil(object) {
+		return skipped{}, nil
+	}
+	return member(object, expr.name)
+}
+
+func (p *Interpreter) VisitExprIndexObj(expr *ExprIndex) (interface{}, error) {
+	object, err := p.link(expr.object)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := object.(skipped); ok {
+		return object, nil
+	}
+
+	var index, end interface{}
+	if expr.index != nil {
+		if index, err = p.evaluate(expr.index); err != nil {
+			return nil, err
+		}
+	}
+	if expr.end != nil {
+		if end, err = p.evaluate(expr.end); err != nil {
+			return nil, err
+		}
+	}
+
+	if expr.colon == nil {
+		return element(object, index, expr.bracket)
+	}
+	return slice(object, index, end, expr.bracket)
+}
+
+// VisitExprConditionalObj evaluates only the chosen branch.
+func (p *Interpreter) VisitExprConditionalObj(expr *ExprConditional) (interface{}, error) {
+	condition, err := p.evaluate(expr.condition)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := isTruthy(condition)
+	if err != nil {
+		return nil, locateExpr(err, expr.condition, expr.question)
+	}
+	if ok {
+		return p.evaluate(expr.then)
+	}
+	return p.evaluate(expr.otherwise)
+}
+
+func (p *Interpreter) VisitExprBadObj(expr *ExprBad) (interface{}, error) {
+	return nil, locateExpr(runtimeError("invalid expression"), expr, expr.from)
+}
+
 func (p *Interpreter) lookup(name *Token) (interface{}, error) {
 	return p.Environment.Get(name)
 }
@@ -159,59 +265,79 @@ func (p *Interpreter) VisitExprBinaryObj(expr *ExprBinary) (interface{}, error)
 		return nil, err
 	}
 
+	switch expr.operator.typ {
+	case TokenEqualEqual, TokenBangEqual:
+		if isNil(left) || isNil(right) {
+			eq := isNil(left) && isNil(right)
+			return eq == (expr.operator.typ == TokenEqualEqual), nil
+		}
+	case TokenIn, TokenNotIn, TokenContains, TokenContainsAny, TokenIntersects, TokenContainsAll, TokenSubsetOf:
+		res, err := membership(expr.operator.typ, left, right)
+		if err != nil {
+			return nil, locate(err, expr.operator)
+		}
+		return res, nil
+	}
+
+	if expr.operator.typ == TokenPlus {
+		ls, lIsString := left.(string)
+		rs, rIsString := right.(string)
+		if lIsString && rIsString {
+			return ls + rs, nil
+		}
+	}
+
 	ln, lIsNumber := toNumber(left)
 	rn, rIsNumber := toNumber(right)
 
 	if lIsNumber != rIsNumber {
-		return nil, RuntimeError{msg: fmt.Sprintf("%+v %s %+v is not number",
-			left, expr.operator.lexeme, right)}
+		return nil, RuntimeErrWithToken(expr.operator, fmt.Sprintf("%+v %s %+v is not number",
+			left, expr.operator.lexeme, right))
 	}
 
 	switch expr.operator.typ {
-	// +-*/
+	case TokenPlus, TokenMinus, TokenStar, TokenSlash, TokenPercent:
+		if !lIsNumber {
+			return nil, RuntimeErrWithToken(expr.operator, fmt.Sprintf("%+v %s %+v is not number",
+				left, expr.operator.lexeme, right))
+		}
+		return arithmetic(expr.operator, ln, rn)
 	case TokenGreater:
-		return ln > rn, nil
+		return compareNumbers(ln, rn) > 0, nil
 	case TokenGreaterEqual:
-		return ln >= rn, nil
+		return compareNumbers(ln, rn) >= 0, nil
 	case TokenLess:
-		return ln < rn, nil
+		return compareNumbers(ln, rn) < 0, nil
 	case TokenLessEqual:
-		return ln <= rn, nil
+		return compareNumbers(ln, rn) <= 0, nil
 	case TokenEqualEqual:
-		if lIsNumber {
-			return p.isEqual(ln, rn)
-		}
-		return p.isEqual(left, right)
+		return isEqual(left, right)
 	case TokenBangEqual:
-		var eq bool
-		var err error
-		if lIsNumber {
-			eq, err = p.isEqual(ln, rn)
-		} else {
-			eq, err = p.isEqual(left, right)
-		}
+		eq, err := isEqual(left, right)
 		return !eq, err
 	default:
-		return nil, RuntimeError{msg: fmt.Sprintf("unknown operator %s", expr.operator.lexeme)}
+		return nil, RuntimeErrWithToken(expr.operator, "unknown operator")
 	}
 }
 
 //revive:enable:cyclomatic
 
 func (p *Interpreter) VisitExprCallObj(expr *ExprCall) (interface{}, error) {
-	callee, err := p.evaluate(expr.callee)
+	callee, err := p.link(expr.callee)
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := callee.(skipped); ok {
+		return callee, nil
+	}
 
 	callable, ok := callee.(Callable)
 	if !ok {
 		return nil, RuntimeErrWithToken(expr.paren, "not callable")
 	}
 
-	if len(expr.arguments) != callable.ArgNum() {
-		return nil, RuntimeErrWithToken(expr.paren,
-			fmt.Sprintf("want %d but got %d arguments", callable.ArgNum(), len(expr.arguments)))
+	if min, max := arity(callable); len(expr.arguments) < min || max >= 0 && len(expr.arguments) > max {
+		return nil, arityError(expr, callable, min, max)
 	}
 
 	arguments := make([]interface{}, 0, len(expr.arguments))
@@ -223,47 +349,112 @@ func (p *Interpreter) VisitExprCallObj(expr *ExprCall) (interface{}, error) {
 		arguments = append(arguments, argV)
 	}
 
-	return callable.Call(arguments), nil
+	p.calls++
+	if err := LimitCalls.check(p.calls, p.Limits.MaxCalls); err != nil {
+		return nil, err
+	}
+
+	var res interface{}
+	if c, ok := callable.(ContextCallable); ok {
+		res, err = c.CallContext(p.context(), arguments)
+	} else {
+		res, err = callable.Call(arguments)
+	}
+	if err != nil {
+		callErr := &CallError{Name: calleeName(expr.callee), Token: expr.paren, Err: err}
+		located := newError(KindRuntime, expr.paren, callErr.Error())
+		located.Err = callErr
+		return nil, located
+	}
+	if isList(res) {
+		if err := LimitListLength.check(reflect.ValueOf(res).Len(), p.Limits.MaxListLength); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func arityError(expr *ExprCall, callable Callable, min, max int) error {
+	var want string
+	switch {
+	case min == max:
+		want = fmt.Sprintf("%d", min)
+	case max < 0:
+		want = fmt.Sprintf("at least %d", min)
+	default:
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+
+	msg := fmt.Sprintf("%s: want %s but got %d arguments",
+		calleeName(expr.callee), want, len(expr.arguments))
+	if s, ok := callable.(fmt.Stringer); ok {
+		msg += ", signature: " + s.String()
+	}
+	return RuntimeErrWithToken(expr.paren, msg)
+}
+
+// calleeName returns the name of the function called by a call expression.
+func calleeName(callee Expr) string {
+	switch e := callee.(type) {
+	case *ExprVariable:
+		return e.name.lexeme
+	case *ExprGet:
+		return e.name.lexeme
+	default:
+		return callee.AcceptStr(&AstPrinter{})
+	}
 }
 
 func (p *Interpreter) VisitExprArrayObj(expr *ExprArray) (interface{}, error) {
 	items := make([]interface{}, 0, len(expr.items))
 	for _, item := range expr.items {
-		items = append(items, item)
+		v, err := p.evaluate(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
 	}
 	return items, nil
 }
 
+// isTruthy requires obj to be a bool, treating nil as false.
 func isTruthy(obj interface{}) (bool, error) {
-	if obj == nil {
-		return false, RuntimeError{"nil value"}
+	if isNil(obj) {
+		return false, nil
 	}
 
 	b, ok := obj.(bool)
 	if !ok {
-		return false, RuntimeError{fmt.Sprintf("not bool value: %+v", obj)}
+		return false, runtimeError("not bool value: %+v", obj)
 	}
 
 	return b, nil
 }
 
 // https://golang.google.cn/ref/spec#Comparison_operators
-func (p *Interpreter) isEqual(a, b interface{}) (res bool, err error) {
+func isEqual(a, b interface{}) (res bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			res = false
 		}
 	}()
 
-	isSlice := reflect.TypeOf(a).Kind() == reflect.Slice
-	if isSlice {
-		return p.isSliceEqual(a, b)
+	if isList(a) && isList(b) {
+		return isSliceEqual(a, b)
+	}
+
+	an, aIsNumber := toNumber(a)
+	bn, bIsNumber := toNumber(b)
+	if aIsNumber && bIsNumber {
+		return compareNumbers(an, bn) == 0, nil
 	}
 
 	return a == b, nil
 }
 
-func (p *Interpreter) isSliceEqual(a, b interface{}) (res bool, err error) {
+// isSliceEqual compares two lists element by element, so an array literal
+// equals a Go slice of any element type holding the same values.
+func isSliceEqual(a, b interface{}) (res bool, err error) {
 	va := reflect.ValueOf(a)
 	vb := reflect.ValueOf(b)
 
@@ -273,61 +464,134 @@ func (p *Interpreter) isSliceEqual(a, b interface{}) (res bool, err error) {
 
 	length := va.Len()
 	for i := 0; i < length; i++ {
-		itema := va.Index(i)
-		itemb := vb.Index(i)
+		eq, err := isEqual(va.Index(i).Interface(), vb.Index(i).Interface())
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func membership(operator TokenType, left, right interface{}) (bool, error) {
+	switch operator {
+	case TokenIn:
+		return contains(right, left)
+	case TokenNotIn:
+		found, err := contains(right, left)
+		return !found, err
+	case TokenContains:
+		return contains(left, right)
+	case TokenContainsAny, TokenIntersects:
+		return containsAny(listItems(left), listItems(right))
+	case TokenContainsAll:
+		return containsAll(listItems(left), listItems(right))
+	default: // TokenSubsetOf
+		return containsAll(listItems(right), listItems(left))
+	}
+}
+
+// contains reports whether item is an element of the list container, or a
+// substring of the string container.
+// A nil container contains nothing.
+func contains(container, item interface{}) (bool, error) {
+	if isNil(container) {
+		return false, nil
+	}
 
-		converted, ok := TryConvert(itema, itemb.Type())
+	if str, ok := container.(string); ok {
+		sub, ok := item.(string)
 		if !ok {
-			return false, nil
+			return false, runtimeError("%+v (%T) is not string", item, item)
 		}
+		return strings.Contains(str, sub), nil
+	}
 
-		ai := converted.Interface()
-		bi := itemb.Interface()
+	if !isList(container) {
+		return false, runtimeError("%+v (%T) is not list or string", container, container)
+	}
 
-		if ae, ok := ai.(Expr); ok {
-			if ai, err = p.evaluate(ae); err != nil {
-				return false, err
-			}
+	v := reflect.ValueOf(container)
+	for i := 0; i < v.Len(); i++ {
+		eq, err := isEqual(v.Index(i).Interface(), item)
+		if err != nil {
+			return false, err
 		}
-		if be, ok := bi.(Expr); ok {
-			if bi, err = p.evaluate(be); err != nil {
-				return false, err
-			}
+		if eq {
+			return true, nil
 		}
+	}
 
-		if ai != bi {
-			return false, nil
-		}
+	return false, nil
+}
+
+// listItems returns the elements of a list, treating any other value as a
+// list of one element, so a function returning a single entity can be used
+// with the set operators as well. nil is an empty list.
+func listItems(obj interface{}) []interface{} {
+	if obj == nil {
+		return nil
+	}
+	if !isList(obj) {
+		return []interface{}{obj}
 	}
 
-	return true, nil
+	v := reflect.ValueOf(obj)
+	items := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		items = append(items, v.Index(i).Interface())
+	}
+	return items
 }
 
-func toNumber(obj interface{}) (float64, bool) {
-	switch n := obj.(type) {
-	case int:
-		return float64(n), true
-	case int32:
-		return float64(n), true
-	case int64:
-		return float64(n), true
-	case float32:
-		return float64(n), true
-	case float64:
-		return n, true
-	default:
-		return 0, false
+// containsAny reports whether at least one of items is an element of set.
+func containsAny(set, items []interface{}) (bool, error) {
+	for _, item := range items {
+		found, err := contains(set, item)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
 	}
+	return false, nil
 }
 
-type RuntimeError struct {
-	msg string
+// containsAll reports whether every one of items is an element of set.
+func containsAll(set, items []interface{}) (bool, error) {
+	for _, item := range items {
+		found, err := contains(set, item)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
-func RuntimeErrWithToken(t *Token, msg string) RuntimeError {
-	return RuntimeError{msg: fmt.Sprintf("%s: %s", t.lexeme, msg)}
+// isNil reports whether obj is nil, or a nil pointer, map, slice, function,
+// channel or interface returned by Go code.
+func isNil(obj interface{}) bool {
+	if obj == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
 }
 
-func (e RuntimeError) Error() string {
-	return e.msg
+func isList(obj interface{}) bool {
+	if obj == nil {
+		return false
+	}
+	kind := reflect.TypeOf(obj).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
 }
@@ -1,6 +1,11 @@
 package expr
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -20,6 +25,11 @@ func toExpr(src string) (Expr, error) {
 	return expr, nil
 }
 
+func isRuntimeError(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Kind == KindRuntime
+}
+
 func Test_equal(t *testing.T) {
 	p := NewInterpreter()
 
@@ -497,8 +507,7 @@ func Test_runtime_error(t *testing.T) {
 	}
 
 	res, err := p.Interpret(e)
-	_, ok := err.(RuntimeError)
-	if !ok {
+	if !isRuntimeError(err) {
 		t.Fatal("want RuntimeError")
 	}
 
@@ -506,3 +515,1245 @@ func Test_runtime_error(t *testing.T) {
 		t.Fatalf("want nil result, got %+v", res)
 	}
 }
+
+func Test_arithmetic(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string]interface{}{
+		"功效":     []string{"补水", "抗皱"},
+		"price":  120,
+		"uint":   uint64(math.MaxUint64),
+		"uint-1": uint64(math.MaxUint64 - 1),
+		"int8":   int8(-128),
+	}
+	nerEntities := func(name string) interface{} {
+		return data[name]
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	length := func(v []string) int {
+		return len(v)
+	}
+	if err := p.Environment.DefineGoFunc("len", length); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `1 + 2 == 3`, expect: true},
+		{src: `5 - 3 == 2`, expect: true},
+		{src: `2 * 3 == 6`, expect: true},
+		{src: `7 / 2 == 3`, expect: true},
+		{src: `7.0 / 2 == 3.5`, expect: true},
+		{src: `-7 / 2 == -3`, expect: true},
+		{src: `7 % -4 == 3`, expect: true},
+		{src: `7.5 % 2 == 1.5`, expect: true},
+		{src: `1e6 == 1_000_000`, expect: true},
+		{src: `1.5E-1 == .15`, expect: true},
+		{src: `0x1F == 31`, expect: true},
+		{src: `0XFF_FF == 65535`, expect: true},
+		{src: `.5 + .5 == 1`, expect: true},
+		{src: `9007199254740993 > 9007199254740992`, expect: true},
+		{src: `9007199254740993 == 9007199254740992`, expect: false},
+		{src: `9223372036854775807 - 1 == 9223372036854775806`, expect: true},
+		{src: `ner_entities("uint") + 1.0 == 18446744073709551616.0`, expect: true},
+		{src: `ner_entities("uint") == ner_entities("uint-1")`, expect: false},
+		{src: `ner_entities("uint") > ner_entities("uint-1")`, expect: true},
+		{src: `ner_entities("uint") > 9223372036854775807`, expect: true},
+		{src: `ner_entities("uint") - 1 == ner_entities("uint-1")`, expect: true},
+		{src: `ner_entities("uint") - ner_entities("uint-1") == 1`, expect: true},
+		{src: `ner_entities("uint") / 2 == 9223372036854775807`, expect: true},
+		{src: `ner_entities("int8") * 2 == -256`, expect: true},
+		{src: `7 % 4 == 3`, expect: true},
+		{src: `1 + 2 * 3 == 7`, expect: true},
+		{src: `(1 + 2) * 3 == 9`, expect: true},
+		{src: `10 - 4 - 3 == 3`, expect: true},
+		{src: `-2 * -3 == 6`, expect: true},
+		{src: `1 + 1 > 1`, expect: true},
+		{src: `"补" + "水" == "补水"`, expect: true},
+		{src: `len(ner_entities("功效")) + 1 > 2`, expect: true},
+		{src: `ner_entities("price") * 0.8 < 100`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_arithmetic_error(t *testing.T) {
+	p := NewInterpreter()
+	p.Environment.Define("max_uint", uint64(math.MaxUint64))
+
+	for _, src := range []string{`1 / 0`, `1 % 0`, `1.0 / 0`, `"a" + 1`, `"a" * "b"`, `true - false`,
+		`9223372036854775807 + 1`, `-9223372036854775807 - 2`, `4611686018427387904 * 2`,
+		`-(-9223372036854775807 - 1)`, `(-9223372036854775807 - 1) / -1`,
+		`max_uint + 1`, `-max_uint`, `max_uint % 0`} {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if !isRuntimeError(err) {
+				t.Fatalf("want RuntimeError, got %v", err)
+			}
+			if res != nil {
+				t.Fatalf("want nil result, got %+v", res)
+			}
+		})
+	}
+}
+
+func Test_array_go_func(t *testing.T) {
+	p := NewInterpreter()
+
+	funcs := map[string]interface{}{
+		"join": func(items []string) string {
+			return strings.Join(items, ",")
+		},
+		"sum": func(items []int) int {
+			total := 0
+			for _, item := range items {
+				total += item
+			}
+			return total
+		},
+		"echo": func(items []interface{}) []interface{} {
+			return items
+		},
+		"strs": func() []string {
+			return []string{"补水", "抗皱"}
+		},
+		"ints": func() []int {
+			return []int{1, 2, 3}
+		},
+		"nested": func() [][]string {
+			return [][]string{{"a"}, {"b", "c"}}
+		},
+	}
+	for name, f := range funcs {
+		if err := p.Environment.DefineGoFunc(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `[1, 2] == [1, 2]`, expect: true},
+		{src: `[1, 2] == [2, 1]`, expect: false},
+		{src: `[1, 2] == [1, 2, 3]`, expect: false},
+		{src: `[1 + 1, "a"] == [2, "a"]`, expect: true},
+		{src: `[[1], ["a"]] == [[1], ["a"]]`, expect: true},
+		{src: `[] == []`, expect: true},
+		{src: `join(["补水", "抗皱"]) == "补水,抗皱"`, expect: true},
+		{src: `join([]) == ""`, expect: true},
+		{src: `sum([1, 2, 3]) == 6`, expect: true},
+		{src: `echo([1, "a", true]) == [1, "a", true]`, expect: true},
+		{src: `strs() == ["补水", "抗皱"]`, expect: true},
+		{src: `strs() != ["抗皱", "补水"]`, expect: true},
+		{src: `ints() == [1, 2, 3]`, expect: true},
+		{src: `[1, 2, 3] == ints()`, expect: true},
+		{src: `nested() == [["a"], ["b", "c"]]`, expect: true},
+		{src: `join(strs()) == "补水,抗皱"`, expect: true},
+		{src: `sum(ints()) == 6`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_array_value(t *testing.T) {
+	p := NewInterpreter()
+
+	e, err := toExpr(`[1, "a", true, 1 + 1]`)
+	if err != nil {
+		t.Fatalf("parse expr failed: %s", err)
+	}
+
+	res, err := p.Interpret(e)
+	if err != nil {
+		t.Fatalf("interpret expr failed: %s", err)
+	}
+
+	expect := []interface{}{int64(1), "a", true, int64(2)}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("expect %#v, got %#v", expect, res)
+	}
+}
+
+func Test_in(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string]interface{}{
+		"功效":   []string{"补水", "抗皱"},
+		"产品类型": "补水面膜",
+		"ids":  []int{1, 2, 3},
+	}
+	nerEntities := func(name string) interface{} {
+		return data[name]
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `"补水" in ner_entities("功效")`, expect: true},
+		{src: `"美白" in ner_entities("功效")`, expect: false},
+		{src: `"美白" not in ner_entities("功效")`, expect: true},
+		{src: `"补水" not in ner_entities("功效")`, expect: false},
+		{src: `"补水" not
+			in ner_entities("功效")`, expect: false},
+		{src: `2 in ner_entities("ids")`, expect: true},
+		{src: `4 in ner_entities("ids")`, expect: false},
+		{src: `1 in [1, "a"]`, expect: true},
+		{src: `"a" in [1, "a"]`, expect: true},
+		{src: `[1] in [[1], [2]]`, expect: true},
+		{src: `1 in []`, expect: false},
+		{src: `"面膜" in ner_entities("产品类型")`, expect: true},
+		{src: `"精华" in ner_entities("产品类型")`, expect: false},
+		{src: `"" in "abc"`, expect: true},
+		{src: `1 + 1 in [2] == true`, expect: true},
+		{src: `"补水" in ner_entities("功效") and "抗皱" in ner_entities("功效")`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_set_operators(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string]interface{}{
+		"肤质":   []string{"干性", "敏感性"},
+		"功效":   []interface{}{"补水", "抗皱"},
+		"产品类型": "面膜",
+		"ids":  []int{1, 2, 3},
+	}
+	nerEntities := func(name string) interface{} {
+		return data[name]
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `ner_entities("肤质") contains "干性"`, expect: true},
+		{src: `ner_entities("肤质") contains "油性"`, expect: false},
+		{src: `ner_entities("产品类型") contains "面"`, expect: true},
+		{src: `ner_entities("肤质") contains_any ["干性", "混合性"]`, expect: true},
+		{src: `ner_entities("肤质") contains_any ["油性", "混合性"]`, expect: false},
+		{src: `ner_entities("肤质") contains_any []`, expect: false},
+		{src: `ner_entities("肤质") contains_all ["敏感性", "干性"]`, expect: true},
+		{src: `ner_entities("肤质") contains_all ["干性", "油性"]`, expect: false},
+		{src: `ner_entities("肤质") contains_all []`, expect: true},
+		{src: `ner_entities("肤质") subset_of ["干性", "敏感性", "油性"]`, expect: true},
+		{src: `ner_entities("肤质") subset_of ["干性"]`, expect: false},
+		{src: `[] subset_of ner_entities("肤质")`, expect: true},
+		{src: `ner_entities("功效") intersects ["抗皱"]`, expect: true},
+		{src: `["美白"] intersects ner_entities("功效")`, expect: false},
+		{src: `ner_entities("功效") contains_all ner_entities("功效")`, expect: true},
+		{src: `ner_entities("ids") contains_all [3, 1]`, expect: true},
+		{src: `ner_entities("产品类型") contains_any ["面膜", "精华"]`, expect: true},
+		{src: `ner_entities("产品类型") subset_of ["面膜", "精华"]`, expect: true},
+		{src: `ner_entities("肤质") contains_any ["干性"] == true`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_enclosed_environment(t *testing.T) {
+	base := NewEnvironment()
+	nerEntities := func(doc map[string][]string, name string) []string {
+		return doc[name]
+	}
+	if err := base.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	base.Define("threshold", 1)
+
+	e, err := toExpr(`"补水" in ner_entities(doc, "功效") and threshold > 0`)
+	if err != nil {
+		t.Fatalf("parse expr failed: %s", err)
+	}
+
+	docs := []struct {
+		doc    map[string][]string
+		expect bool
+	}{
+		{doc: map[string][]string{"功效": {"补水"}}, expect: true},
+		{doc: map[string][]string{"功效": {"美白"}}, expect: false},
+	}
+	for _, tc := range docs {
+		env := NewEnclosedEnvironment(base)
+		env.Define("doc", tc.doc)
+
+		res, err := (&Interpreter{Environment: env}).Interpret(e)
+		if err != nil {
+			t.Fatalf("interpret expr failed: %s", err)
+		}
+		if res != tc.expect {
+			t.Fatalf("expect %v, got %v", tc.expect, res)
+		}
+	}
+
+	// shadowing a symbol does not modify the enclosing environment
+	env := NewEnclosedEnvironment(base)
+	env.Define("threshold", 0)
+	if v, _ := env.Get(NewToken(TokenIdentifier, "threshold", nil, 1)); v != 0 {
+		t.Fatalf("expect shadowed threshold 0, got %v", v)
+	}
+	if v, _ := base.Get(NewToken(TokenIdentifier, "threshold", nil, 1)); v != 1 {
+		t.Fatalf("expect base threshold 1, got %v", v)
+	}
+	if _, err := base.Get(NewToken(TokenIdentifier, "doc", nil, 1)); err == nil {
+		t.Fatal("doc should not be defined in the base environment")
+	}
+
+	_, err = env.Get(NewToken(TokenIdentifier, "unknown", nil, 3))
+	if err == nil || err.Error() != "[line 3:0] undefined symbol unknown" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type testProfile struct {
+	Age    int
+	Gender string `expr:"gender"`
+	secret string
+}
+
+type testBase struct {
+	ID string `expr:"id"`
+}
+
+type testUser struct {
+	testBase
+	Name     string
+	Profile  *testProfile `expr:"profile"`
+	Tags     []string     `expr:"tags"`
+	Password string       `expr:"-"`
+	Entities map[string][]string
+}
+
+func Test_get(t *testing.T) {
+	p := NewInterpreter()
+
+	p.Environment.Define("user", &testUser{
+		testBase: testBase{ID: "u1"},
+		Name:     "小明",
+		Profile:  &testProfile{Age: 20, Gender: "女", secret: "x"},
+		Tags:     []string{"vip"},
+		Password: "123456",
+		Entities: map[string][]string{"肤质": {"干性"}},
+	})
+	p.Environment.Define("doc", map[string]interface{}{
+		"title": "补水面膜",
+		"meta": map[string]int{
+			"likes": 10,
+		},
+	})
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `user.Name == "小明"`, expect: true},
+		{src: `user.profile.Age > 18`, expect: true},
+		{src: `user.profile.gender == "女"`, expect: true},
+		{src: `user.id == "u1"`, expect: true},
+		{src: `"vip" in user.tags`, expect: true},
+		{src: `user.Entities.肤质 == ["干性"]`, expect: true},
+		{src: `doc.title == "补水面膜"`, expect: true},
+		{src: `doc.meta.likes + 1 == 11`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_get_error(t *testing.T) {
+	p := NewInterpreter()
+
+	p.Environment.Define("user", testUser{Profile: &testProfile{}})
+	p.Environment.Define("nobody", (*testUser)(nil))
+	p.Environment.Define("ids", map[int]string{1: "a"})
+
+	for _, src := range []string{
+		`user.Password`,
+		`user.profile.secret`,
+		`user.profile.Gender`,
+		`user.unknown`,
+		`user.Name.length`,
+		`nobody.Name`,
+		`ids.a`,
+	} {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			if _, err := p.Interpret(e); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
+
+func Test_index(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string]interface{}{
+		"产品类型": []string{"面膜", "精华"},
+		"品牌":   "兰蔻小黑瓶",
+	}
+	nerEntities := func(name string) interface{} {
+		return data[name]
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	p.Environment.Define("doc", map[string]interface{}{
+		"entities": map[string][]string{"肤质": {"干性", "油性"}},
+	})
+	p.Environment.Define("scores", [3]int{1, 2, 3})
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `ner_entities("产品类型")[0] == "面膜"`, expect: true},
+		{src: `ner_entities("产品类型")[1] == "精华"`, expect: true},
+		{src: `ner_entities("产品类型")[-1] == "精华"`, expect: true},
+		{src: `ner_entities("产品类型")[-2] == "面膜"`, expect: true},
+		{src: `ner_entities("产品类型")[1 - 1] == "面膜"`, expect: true},
+		{src: `ner_entities("产品类型")[0:1] == ["面膜"]`, expect: true},
+		{src: `ner_entities("产品类型")[1:] == ["精华"]`, expect: true},
+		{src: `ner_entities("产品类型")[:] == ["面膜", "精华"]`, expect: true},
+		{src: `ner_entities("产品类型")[:-1] == ["面膜"]`, expect: true},
+		{src: `ner_entities("产品类型")[2:] == []`, expect: true},
+		{src: `ner_entities("品牌")[0] == "兰"`, expect: true},
+		{src: `ner_entities("品牌")[-1] == "瓶"`, expect: true},
+		{src: `ner_entities("品牌")[:2] == "兰蔻"`, expect: true},
+		{src: `ner_entities("品牌")[2:] == "小黑瓶"`, expect: true},
+		{src: `doc["entities"]["肤质"][1] == "油性"`, expect: true},
+		{src: `doc.entities["肤质"] == ["干性", "油性"]`, expect: true},
+		{src: `[1, [2, 3]][1][0] == 2`, expect: true},
+		{src: `scores[2] == 3`, expect: true},
+		{src: `scores[1:] == [2, 3]`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_index_error(t *testing.T) {
+	p := NewInterpreter()
+
+	p.Environment.Define("list", []string{"a", "b"})
+	p.Environment.Define("doc", map[string]string{"a": "b"})
+	p.Environment.Define("ids", map[int]string{1: "a"})
+
+	for _, src := range []string{
+		`list[2]`,
+		`list[-3]`,
+		`list[0.5]`,
+		`list["a"]`,
+		`list[1:0]`,
+		`list[:3]`,
+		`"中文"[2]`,
+		`doc[1]`,
+		`ids[1]`,
+		`1[0]`,
+		`true[0:1]`,
+	} {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			_, err = p.Interpret(e)
+			if !isRuntimeError(err) {
+				t.Fatalf("want RuntimeError, got %T %v", err, err)
+			}
+			if strings.Contains(err.Error(), "stack info") {
+				t.Fatalf("want error instead of recovered panic, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_nil(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string]interface{}{
+		"肤质":    []string{"干性"},
+		"nil列表": []string(nil),
+	}
+	nerEntities := func(name string) interface{} {
+		return data[name]
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	nilUser := func() *testUser {
+		return nil
+	}
+	if err := p.Environment.DefineGoFunc("nil_user", nilUser); err != nil {
+		t.Fatal(err)
+	}
+	isNilList := func(v []string) bool {
+		return v == nil
+	}
+	if err := p.Environment.DefineGoFunc("is_nil_list", isNilList); err != nil {
+		t.Fatal(err)
+	}
+	p.Environment.Define("user", &testUser{Name: "小明", Profile: nil})
+	p.Environment.Define("doc", map[string]interface{}{"title": "面膜"})
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `nil == nil`, expect: true},
+		{src: `nil != nil`, expect: false},
+		{src: `1 == nil`, expect: false},
+		{src: `nil != 1`, expect: true},
+		{src: `"a" == nil`, expect: false},
+		{src: `[] == nil`, expect: false},
+		{src: `ner_entities("unknown") == nil`, expect: true},
+		{src: `ner_entities("肤质") != nil`, expect: true},
+		{src: `ner_entities("nil列表") == nil`, expect: true},
+		{src: `nil_user() == nil`, expect: true},
+		{src: `!nil`, expect: true},
+		{src: `nil or true`, expect: true},
+		{src: `ner_entities("unknown") ?? [] == []`, expect: true},
+		{src: `(ner_entities("unknown") ?? ["油性"]) == ["油性"]`, expect: true},
+		{src: `(ner_entities("肤质") ?? ["油性"]) == ["干性"]`, expect: true},
+		{src: `ner_entities("unknown") ?? ner_entities("unknown") ?? 1 == 1`, expect: true},
+		{src: `ner_entities("unknown") ?? 0 + 1 > 0`, expect: true},
+		{src: `"干性" in ner_entities("unknown")`, expect: false},
+		{src: `ner_entities("unknown") contains_any ["干性"]`, expect: false},
+		{src: `ner_entities("unknown") subset_of ["干性"]`, expect: true},
+		{src: `doc.unknown == nil`, expect: true},
+		{src: `doc["unknown"] == nil`, expect: true},
+		{src: `(doc.unknown ?? "默认") == "默认"`, expect: true},
+		{src: `user.profile?.Age == nil`, expect: true},
+		{src: `nil_user()?.Name == nil`, expect: true},
+		{src: `user?.Name == "小明"`, expect: true},
+		{src: `(user.profile?.Age ?? 18) == 18`, expect: true},
+		{src: `doc.profile?.age.value == nil`, expect: true},
+		{src: `nil_user()?.Profile.Age == nil`, expect: true},
+		{src: `doc.tags?.first[0].name("x") == nil`, expect: true},
+		{src: `is_nil_list(nil)`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_nil_error(t *testing.T) {
+	p := NewInterpreter()
+
+	p.Environment.Define("user", &testUser{})
+	p.Environment.Define("doc", map[string]interface{}{})
+
+	for _, src := range []string{
+		`user.profile.Age`,
+		`nil.a`,
+		`nil[0]`,
+		`-nil`,
+		`nil + 1`,
+		`(doc.profile?.age).value`,
+		`doc.profile.age?.value`,
+	} {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			_, err = p.Interpret(e)
+			if !isRuntimeError(err) {
+				t.Fatalf("want RuntimeError, got %T %v", err, err)
+			}
+		})
+	}
+}
+
+func Test_go_func_error(t *testing.T) {
+	p := NewInterpreter()
+
+	errTimeout := errors.New("ner timeout")
+	nerEntities := func(name string) ([]string, error) {
+		if name == "timeout" {
+			return nil, errTimeout
+		}
+		return []string{"干性"}, nil
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	check := func(ok bool) error {
+		if !ok {
+			return errors.New("check failed")
+		}
+		return nil
+	}
+	if err := p.Environment.DefineGoFunc("check", check); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := toExpr(`ner_entities("肤质") == ["干性"]`)
+	if err != nil {
+		t.Fatalf("parse expr failed: %s", err)
+	}
+	if res, err := p.Interpret(e); err != nil || res != true {
+		t.Fatalf("expect true, got %v %v", res, err)
+	}
+
+	e, err = toExpr(`check(true) == nil`)
+	if err != nil {
+		t.Fatalf("parse expr failed: %s", err)
+	}
+	if res, err := p.Interpret(e); err != nil || res != true {
+		t.Fatalf("expect true, got %v %v", res, err)
+	}
+
+	testCases := []struct {
+		src    string
+		name   string
+		line   int
+		target error
+	}{
+		{src: "true and\nner_entities(\"timeout\") == []", name: "ner_entities", line: 2, target: errTimeout},
+		{src: `check(false)`, name: "check", line: 1},
+		{src: `check("yes")`, name: "check", line: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			_, err = p.Interpret(e)
+			var callErr *CallError
+			if !errors.As(err, &callErr) {
+				t.Fatalf("want CallError, got %T %v", err, err)
+			}
+			if callErr.Name != tc.name || callErr.Token.line != tc.line {
+				t.Fatalf("want error from %s at line %d, got %v", tc.name, tc.line, err)
+			}
+			if tc.target != nil && !errors.Is(err, tc.target) {
+				t.Fatalf("want %v, got %v", tc.target, err)
+			}
+		})
+	}
+}
+
+func Test_define_go_func(t *testing.T) {
+	env := NewEnvironment()
+
+	valid := []interface{}{
+		func() {},
+		func() int { return 0 },
+		func() error { return nil },
+		func() (int, error) { return 0, nil },
+	}
+	for _, f := range valid {
+		if err := env.DefineGoFunc("f", f); err != nil {
+			t.Fatalf("define %T: %s", f, err)
+		}
+	}
+
+	invalid := []interface{}{
+		nil,
+		1,
+		func() (int, int) { return 0, 0 },
+		func() (int, string, error) { return 0, "", nil },
+	}
+	for _, f := range invalid {
+		if err := env.DefineGoFunc("f", f); err == nil {
+			t.Fatalf("define %T: want error", f)
+		}
+	}
+}
+
+// testOptionalFunc returns its first argument, or the optional second
+// argument if the first one is nil.
+type testOptionalFunc struct{}
+
+func (testOptionalFunc) Call(args []interface{}) (interface{}, error) {
+	if args[0] == nil && len(args) > 1 {
+		return args[1], nil
+	}
+	return args[0], nil
+}
+
+func (testOptionalFunc) ArgNum() int { return 1 }
+
+func (testOptionalFunc) Arity() (min, max int) { return 1, 2 }
+
+func Test_variadic(t *testing.T) {
+	p := NewInterpreter()
+
+	data := map[string][]string{
+		"功效": {"补水"},
+	}
+	anyEntity := func(names ...string) bool {
+		for _, name := range names {
+			if len(data[name]) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if err := p.Environment.DefineGoFunc("any_entity", anyEntity); err != nil {
+		t.Fatal(err)
+	}
+	hasPrefix := func(prefix string, names ...string) bool {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if err := p.Environment.DefineGoFunc("has_prefix", hasPrefix); err != nil {
+		t.Fatal(err)
+	}
+	p.Environment.Define("default", testOptionalFunc{})
+
+	testCases := []struct {
+		name   string
+		src    string
+		expect bool
+	}{
+		{src: `any_entity("肤质", "功效", "成分")`, expect: true},
+		{src: `any_entity("肤质", "成分")`, expect: false},
+		{src: `any_entity()`, expect: false},
+		{src: `has_prefix("补")`, expect: false},
+		{src: `has_prefix("补", "美白", "补水")`, expect: true},
+		{src: `default(1) == 1`, expect: true},
+		{src: `default(nil, 2) == 2`, expect: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Logf("interpret expr failed: %s", err)
+				t.FailNow()
+			}
+
+			b, ok := res.(bool)
+			if !ok {
+				t.Logf("result is not bool: %T %+v", res, res)
+				t.FailNow()
+			}
+			if b != tc.expect {
+				t.Logf("expect %v, got %v", tc.expect, b)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_arity_error(t *testing.T) {
+	p := NewInterpreter()
+
+	nerEntities := func(name string) []string {
+		return nil
+	}
+	if err := p.Environment.DefineGoFunc("ner_entities", nerEntities); err != nil {
+		t.Fatal(err)
+	}
+	hasPrefix := func(prefix string, names ...string) bool {
+		return false
+	}
+	if err := p.Environment.DefineGoFunc("has_prefix", hasPrefix); err != nil {
+		t.Fatal(err)
+	}
+	p.Environment.Define("default", testOptionalFunc{})
+
+	testCases := []struct {
+		src    string
+		expect string
+	}{
+		{
+			src:    `ner_entities("肤质", "功效")`,
+			expect: "[line 1:24] ner_entities: want 1 but got 2 arguments, signature: ner_entities(string) []string",
+		},
+		{
+			src:    `has_prefix()`,
+			expect: "[line 1:12] has_prefix: want at least 1 but got 0 arguments, signature: has_prefix(string, ...string) bool",
+		},
+		{
+			src:    `default(1, 2, 3)`,
+			expect: "[line 1:16] default: want 1 to 2 but got 3 arguments",
+		},
+		{
+			src:    `has_prefix("a", 1)`,
+			expect: "[line 1:18] call has_prefix: argument[1] '1' int64 is not compatible for string",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Logf("parse expr failed: %s", err)
+				t.FailNow()
+			}
+
+			_, err = p.Interpret(e)
+			if err == nil || err.Error() != tc.expect {
+				t.Fatalf("want error %q, got %v", tc.expect, err)
+			}
+		})
+	}
+}
+
+func Test_string(t *testing.T) {
+	p := NewInterpreter()
+
+	testCases := []struct {
+		src    string
+		expect string
+	}{
+		{src: `"补水"`, expect: "补水"},
+		{src: `'补水'`, expect: "补水"},
+		{src: `"say \"hi\""`, expect: `say "hi"`},
+		{src: `'it\'s'`, expect: "it's"},
+		{src: `'say "hi"'`, expect: `say "hi"`},
+		{src: `"a\\b"`, expect: `a\b`},
+		{src: `"a\nb\tc\r"`, expect: "a\nb\tc\r"},
+		{src: `"\u8865\u6C34"`, expect: "补水"},
+		{src: "\"a\nb\"", expect: "a\nb"},
+		{src: "`C:\\dir\\n`", expect: `C:\dir\n`},
+		{src: "`a\n'b'\"c\"`", expect: "a\n'b'\"c\""},
+		{src: `"a" + '\'' + "b"`, expect: "a'b"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Fatalf("interpret expr failed: %s", err)
+			}
+			if res != tc.expect {
+				t.Fatalf("expect %q, got %T %q", tc.expect, res, res)
+			}
+		})
+	}
+}
+
+func Test_number_type(t *testing.T) {
+	p := NewInterpreter()
+
+	testCases := []struct {
+		src    string
+		expect interface{}
+	}{
+		{src: `1 + 2`, expect: int64(3)},
+		{src: `7 / 2`, expect: int64(3)},
+		{src: `-0x10`, expect: int64(-16)},
+		{src: `1 + 2.0`, expect: float64(3)},
+		{src: `1e3`, expect: float64(1000)},
+		{src: `9007199254740993`, expect: int64(9007199254740993)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Fatalf("interpret expr failed: %s", err)
+			}
+			if res != tc.expect {
+				t.Fatalf("expect %T %v, got %T %v", tc.expect, tc.expect, res, res)
+			}
+		})
+	}
+}
+
+func Test_comment(t *testing.T) {
+	p := NewInterpreter()
+
+	testCases := []string{
+		"1 + 1 == 2 // sum",
+		"// leading\n1 == 1",
+		"1 /* one */ + /* and\n two\n */ 1 == 2",
+		"4 / 2 == 2 /**/",
+		"\"// not a comment\" == '//' + ' not a comment'",
+		"1 == 1 // no newline at end /* */",
+	}
+
+	for _, src := range testCases {
+		t.Run(src, func(t *testing.T) {
+			e, err := toExpr(src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Fatalf("interpret expr failed: %s", err)
+			}
+			if res != true {
+				t.Fatalf("expect true, got %v", res)
+			}
+		})
+	}
+
+	src := "a /* x\ny */ and // z\nb"
+	tokens, err := NewScanner(src, WithComments()).ScanTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comments []string
+	for _, token := range tokens {
+		if token.typ == TokenComment {
+			comments = append(comments, fmt.Sprintf("%d:%d %s", token.line, token.column, token.lexeme))
+		}
+	}
+	expect := []string{"1:3 /* x\ny */", "2:10 // z"}
+	if !reflect.DeepEqual(comments, expect) {
+		t.Fatalf("expect comments %q, got %q", expect, comments)
+	}
+	if last := tokens[len(tokens)-2]; last.lexeme != "b" || last.line != 3 {
+		t.Fatalf("unexpected token %s at line %d", last.lexeme, last.line)
+	}
+	if expr, err := NewParser(tokens).Parse(); err != nil || (&AstPrinter{}).Print(expr) != "(and a b)" {
+		t.Fatalf("unexpected expression %v, error %v", expr, err)
+	}
+}
+
+func Test_keywords(t *testing.T) {
+	p := NewInterpreter()
+	p.Environment.Define("a", true)
+	p.Environment.Define("b", false)
+	p.Environment.Define("功效", []string{"补水", "抗皱"})
+
+	chinese := WithKeywords(DefaultKeywords, OperatorAliases, ChineseKeywords)
+	testCases := []struct {
+		src    string
+		opts   []ScannerOption
+		expect string
+	}{
+		{src: `a && b || !b`, expect: "(|| (&& a b) (! b))"},
+		{src: `not a = b`, expect: "(= (not a) b)"},
+		{src: `1 <> 2 and 1 <= 2 and 1 != 2 and 1 == 1`, expect: "(and (and (and (<> 1 2) (<= 1 2)) (!= 1 2)) (== 1 1))"},
+		{src: `"a" not in ["b"]`, expect: "(not in a [] b])"},
+		{src: `非 a 或 b 且 功效 包含 "补水"`, opts: []ScannerOption{chinese}, expect: "(或 (非 a) (且 b (包含 功效 补水)))"},
+		{src: `a and b`, opts: []ScannerOption{chinese}, expect: "(and a b)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			tokens, err := NewScanner(tc.src, tc.opts...).ScanTokens()
+			if err != nil {
+				t.Fatalf("scan failed: %s", err)
+			}
+			e, err := NewParser(tokens).Parse()
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+			if got := (&AstPrinter{}).Print(e); got != tc.expect {
+				t.Fatalf("expect %s, got %s", tc.expect, got)
+			}
+			if _, err := p.Interpret(e); err != nil {
+				t.Fatalf("interpret expr failed: %s", err)
+			}
+		})
+	}
+
+	for _, src := range []string{`a && b`, `a = b`, `a 且 b`} {
+		tokens, err := NewScanner(src, WithKeywords(DefaultKeywords)).ScanTokens()
+		if err == nil {
+			_, err = NewParser(tokens).Parse()
+		}
+		if err == nil {
+			t.Fatalf("%s: want error without aliases", src)
+		}
+	}
+
+	program, err := Compile(`x 或 非 x`, WithScannerOptions(chinese))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := program.Eval(map[string]interface{}{"x": false}); err != nil || res != true {
+		t.Fatalf("want true, got %v %v", res, err)
+	}
+}
+
+func Test_conditional(t *testing.T) {
+	p := NewInterpreter()
+	p.Environment.Define("type", "面膜")
+	p.Environment.Define("price", 89)
+	calls := 0
+	if err := p.Environment.DefineGoFunc("fail", func() (bool, error) {
+		calls++
+		return false, errors.New("should not be called")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		src    string
+		expect interface{}
+		print  string
+	}{
+		{src: `price > (type == "面膜" ? 50 : 100)`, expect: true},
+		{src: `type == "精华" ? 1 : type == "面膜" ? 2 : 3`, expect: int64(2),
+			print: "(? (== type 精华) 1 (? (== type 面膜) 2 3))"},
+		{src: `true ? false ? 1 : 2 : 3`, expect: int64(2), print: "(? true (? false 1 2) 3)"},
+		{src: `price > 50 and price < 100 ? "mid" : "other"`, expect: "mid",
+			print: "(? (and (> price 50) (< price 100)) mid other)"},
+		{src: `true ? 1 : fail()`, expect: int64(1)},
+		{src: `false ? fail() : nil`, expect: nil},
+		{src: `[1, 2, 3][price > 50 ? 1 : 2]`, expect: int64(2)},
+		{src: `price > 50?.5:1`, expect: 0.5, print: "(? (> price 50) 0.5 1)"},
+		{src: `price < 50 ?.5 : 1`, expect: int64(1)},
+		{src: `nil?.x ?? 1`, expect: int64(1)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+			if got := (&AstPrinter{}).Print(e); tc.print != "" && got != tc.print {
+				t.Fatalf("expect %s, got %s", tc.print, got)
+			}
+
+			res, err := p.Interpret(e)
+			if err != nil {
+				t.Fatalf("interpret expr failed: %s", err)
+			}
+			if res != tc.expect {
+				t.Fatalf("expect %T %v, got %T %v", tc.expect, tc.expect, res, res)
+			}
+		})
+	}
+	if calls != 0 {
+		t.Fatalf("unchosen branch evaluated %d times", calls)
+	}
+
+	for _, src := range []string{`1 ? 2 : 3`, `nil ? 1 : 2 > "a"`} {
+		e, err := toExpr(src)
+		if err != nil {
+			t.Fatalf("parse expr failed: %s", err)
+		}
+		if _, err := p.Interpret(e); !isRuntimeError(err) {
+			t.Fatalf("%s: want RuntimeError, got %v", src, err)
+		}
+	}
+	for _, src := range []string{`true ? 1`, `true ? : 2`, `? 1 : 2`} {
+		if _, err := toExpr(src); err == nil {
+			t.Fatalf("%s: want parse error", src)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package expr
+
+import "fmt"
+
+// Limits bounds the work done to compile and evaluate an expression, so that
+// untrusted expressions can't exhaust the resources of the service. A zero
+// value means no limit.
+type Limits struct {
+	// MaxSourceLength is the maximum number of characters of the source.
+	MaxSourceLength int
+	// MaxTokens is the maximum number of tokens of the source.
+	MaxTokens int
+	// MaxDepth is the maximum nesting depth of the expression, counting the
+	// nodes of the AST, so that a chain such as a or b or c has depth 3. It
+	// is checked by Compile, so that Eval never exceeds it for a compiled
+	// Program, and by the Interpreter while evaluating. The Parser only
+	// checks its own recursion, which does not grow with the chains, and
+	// limits it to 1000 if MaxDepth is zero, since a stack overflow can't be
+	// recovered.
+	MaxDepth int
+	// MaxSteps is the maximum number of nodes visited by the Interpreter.
+	MaxSteps int
+	// MaxCalls is the maximum number of function calls by the Interpreter.
+	MaxCalls int
+	// MaxListLength is the maximum length of list literals, and of lists
+	// returned by functions.
+	MaxListLength int
+}
+
+// defaultParseDepth is the maximum recursion depth of the Parser when
+// Limits.MaxDepth is zero.
+const defaultParseDepth = 1000
+
+// LimitKind identifies a field of Limits.
+type LimitKind int
+
+const (
+	LimitSourceLength LimitKind = iota + 1
+	LimitTokens
+	LimitDepth
+	LimitSteps
+	LimitCalls
+	LimitListLength
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitSourceLength:
+		return "source length"
+	case LimitTokens:
+		return "number of tokens"
+	case LimitDepth:
+		return "nesting depth"
+	case LimitSteps:
+		return "number of evaluation steps"
+	case LimitCalls:
+		return "number of function calls"
+	case LimitListLength:
+		return "list length"
+	default:
+		return fmt.Sprintf("LimitKind(%d)", int(k))
+	}
+}
+
+// LimitError is returned when one of the Limits is exceeded. It matches the
+// Err* variable of the same kind with errors.Is.
+type LimitError struct {
+	Kind LimitKind
+	Max  int
+}
+
+var (
+	ErrSourceTooLong = &LimitError{Kind: LimitSourceLength}
+	ErrTooManyTokens = &LimitError{Kind: LimitTokens}
+	ErrTooDeep       = &LimitError{Kind: LimitDepth}
+	ErrTooManySteps  = &LimitError{Kind: LimitSteps}
+	ErrTooManyCalls  = &LimitError{Kind: LimitCalls}
+	ErrListTooLong   = &LimitError{Kind: LimitListLength}
+)
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeds the limit of %d", e.Kind, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	t, ok := target.(*LimitError)
+	return ok && t.Kind == e.Kind
+}
+
+// check returns a LimitError of kind if n exceeds max.
+func (k LimitKind) check(n, max int) error {
+	if max > 0 && n > max {
+		return &LimitError{Kind: k, Max: max}
+	}
+	return nil
+}
+
+// depth returns the nesting depth of expr as counted by the Interpreter, the
+// number of nodes on the longest path from expr to a leaf. Unlike the depth
+// checked by the Parser, it counts the left-associative chains such as
+// a or b or c.
+func depth(expr Expr) int {
+	if expr == nil {
+		return 0
+	}
+	max := 0
+	for _, child := range expr.children() {
+		if d := depth(child); d > max {
+			max = d
+		}
+	}
+	return max + 1
+}
@@ -0,0 +1,224 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// member returns the value of the member name of object, which may be a map
+// with string keys, a struct or a pointer to either of them. Missing map keys
+// yield nil.
+func member(object interface{}, name *Token) (interface{}, error) {
+	v := indirect(reflect.ValueOf(object))
+	if !v.IsValid() {
+		return nil, RuntimeErrWithToken(name, fmt.Sprintf("member %s of nil value", name.lexeme))
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, RuntimeErrWithToken(name, fmt.Sprintf("member %s of map without string keys", name.lexeme))
+		}
+		return mapIndex(v, name.lexeme), nil
+	case reflect.Struct:
+		index, ok := structFields(v.Type())[name.lexeme]
+		if !ok {
+			return nil, RuntimeErrWithToken(name, fmt.Sprintf("no such field %s in %s", name.lexeme, v.Type()))
+		}
+		return v.FieldByIndex(index).Interface(), nil
+	default:
+		return nil, RuntimeErrWithToken(name, fmt.Sprintf("member %s of non-struct value %s", name.lexeme, v.Type()))
+	}
+}
+
+// element returns object[index]. object may be a list or a string, indexed
+// by runes, where a negative index counts from the end, or a map with string
+// keys, where missing keys yield nil.
+func element(object, index interface{}, bracket *Token) (interface{}, error) {
+	v := indirect(reflect.ValueOf(object))
+	if !v.IsValid() {
+		return nil, RuntimeErrWithToken(bracket, "index of nil value")
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		runes := []rune(v.String())
+		i, err := listIndex(index, len(runes), bracket)
+		if err != nil {
+			return nil, err
+		}
+		return string(runes[i]), nil
+	case reflect.Slice, reflect.Array:
+		i, err := listIndex(index, v.Len(), bracket)
+		if err != nil {
+			return nil, err
+		}
+		return v.Index(i).Interface(), nil
+	case reflect.Map:
+		key, ok := index.(string)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return nil, RuntimeErrWithToken(bracket, fmt.Sprintf("map index %+v (%T) is not string", index, index))
+		}
+		return mapIndex(v, key), nil
+	default:
+		return nil, RuntimeErrWithToken(bracket, "index of non-indexable value "+v.Type().String())
+	}
+}
+
+// mapIndex returns the value of key in map v, or nil if the key is missing.
+func mapIndex(v reflect.Value, key string) interface{} {
+	value := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// slice returns object[lo:hi] for a list or a string. A nil bound defaults
+// to the start or the end, and negative bounds count from the end.
+func slice(object, lo, hi interface{}, bracket *Token) (interface{}, error) {
+	v := indirect(reflect.ValueOf(object))
+	if !v.IsValid() {
+		return nil, RuntimeErrWithToken(bracket, "slice of nil value")
+	}
+
+	var runes []rune
+	length := 0
+	switch v.Kind() {
+	case reflect.String:
+		runes = []rune(v.String())
+		length = len(runes)
+	case reflect.Slice, reflect.Array:
+		length = v.Len()
+	default:
+		return nil, RuntimeErrWithToken(bracket, "slice of non-list value "+v.Type().String())
+	}
+
+	start, end := 0, length
+	var err error
+	if lo != nil {
+		if start, err = sliceBound(lo, length, bracket); err != nil {
+			return nil, err
+		}
+	}
+	if hi != nil {
+		if end, err = sliceBound(hi, length, bracket); err != nil {
+			return nil, err
+		}
+	}
+	if start > end {
+		return nil, RuntimeErrWithToken(bracket, fmt.Sprintf("invalid slice indices %d > %d", start, end))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return string(runes[start:end]), nil
+	case reflect.Array:
+		// unaddressable arrays can't be sliced, so copy the elements
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), end-start, end-start)
+		for i := start; i < end; i++ {
+			s.Index(i - start).Set(v.Index(i))
+		}
+		return s.Interface(), nil
+	default:
+		return v.Slice(start, end).Interface(), nil
+	}
+}
+
+// listIndex converts index to an integer in [0, length), counting negative
+// indexes from the end.
+func listIndex(index interface{}, length int, bracket *Token) (int, error) {
+	i, ok := toInt(index)
+	if !ok {
+		return 0, RuntimeErrWithToken(bracket, fmt.Sprintf("index %+v (%T) is not integer", index, index))
+	}
+	if i < 0 {
+		i += length
+	}
+	if i < 0 || i >= length {
+		return 0, RuntimeErrWithToken(bracket, fmt.Sprintf("index %+v out of range with length %d", index, length))
+	}
+	return i, nil
+}
+
+// sliceBound converts bound to an integer in [0, length], counting negative
+// bounds from the end.
+func sliceBound(bound interface{}, length int, bracket *Token) (int, error) {
+	i, ok := toInt(bound)
+	if !ok {
+		return 0, RuntimeErrWithToken(bracket, fmt.Sprintf("slice index %+v (%T) is not integer", bound, bound))
+	}
+	if i < 0 {
+		i += length
+	}
+	if i < 0 || i > length {
+		return 0, RuntimeErrWithToken(bracket, fmt.Sprintf("slice index %+v out of range with length %d", bound, length))
+	}
+	return i, nil
+}
+
+func toInt(obj interface{}) (int, bool) {
+	n, ok := toNumber(obj)
+	if !ok {
+		return 0, false
+	}
+	if n.isFloat {
+		if n.f != math.Trunc(n.f) || math.Abs(n.f) > math.MaxInt32 {
+			return 0, false
+		}
+		return int(n.f), true
+	}
+	if n.isUint || n.i < math.MinInt32 || n.i > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n.i), true
+}
+
+// indirect dereferences pointers and interfaces, returning the zero Value
+// for nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fieldCache maps a struct type to its fields visible to expressions.
+var fieldCache sync.Map // map[reflect.Type]map[string][]int
+
+// structFields returns the exported fields of struct type t, including the
+// promoted ones, keyed by the name in their `expr:"name"` tag or their Go
+// name. Fields tagged with `expr:"-"` are ignored.
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if f.PkgPath != "" || f.Anonymous {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("expr"); ok {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields[name] = f.Index
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
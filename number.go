@@ -0,0 +1,249 @@
+package expr
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var numType = reflect.TypeOf(float64(0))
+
+// number is a numeric value of the interpreter: an int64 unless isFloat, or
+// isUint for the uint64 values above math.MaxInt64. Integer literals are
+// int64 and integer operations stay exact, so that IDs and counts beyond the
+// precision of float64 compare exactly.
+type number struct {
+	isFloat bool
+	isUint  bool
+	i       int64
+	u       uint64
+	f       float64
+}
+
+func intNumber(i int64) number {
+	return number{i: i}
+}
+
+func floatNumber(f float64) number {
+	return number{isFloat: true, f: f}
+}
+
+func (n number) float() float64 {
+	switch {
+	case n.isFloat:
+		return n.f
+	case n.isUint:
+		return float64(n.u)
+	default:
+		return float64(n.i)
+	}
+}
+
+func (n number) value() interface{} {
+	switch {
+	case n.isFloat:
+		return n.f
+	case n.isUint:
+		return n.u
+	default:
+		return n.i
+	}
+}
+
+func (n number) bigInt() *big.Int {
+	if n.isUint {
+		return new(big.Int).SetUint64(n.u)
+	}
+	return big.NewInt(n.i)
+}
+
+// toNumber converts the Go numbers of any kind to a number.
+func toNumber(obj interface{}) (number, bool) {
+	switch n := obj.(type) {
+	case int64:
+		return intNumber(n), true
+	case float64:
+		return floatNumber(n), true
+	case int:
+		return intNumber(int64(n)), true
+	case nil, bool, string:
+		return number{}, false
+	}
+
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intNumber(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return number{isUint: true, u: u}, true
+		}
+		return intNumber(int64(u)), true
+	case reflect.Float32, reflect.Float64:
+		return floatNumber(v.Float()), true
+	default:
+		return number{}, false
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 as a is less than, equal to or greater
+// than b.
+func compareNumbers(a, b number) int {
+	if !a.isFloat && !b.isFloat {
+		switch {
+		case a.isUint && b.isUint:
+			return compareUints(a.u, b.u)
+		case a.isUint:
+			return 1
+		case b.isUint:
+			return -1
+		case a.i < b.i:
+			return -1
+		case a.i > b.i:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	af, bf := a.float(), b.float()
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func negate(operator *Token, n number) (interface{}, error) {
+	if n.isFloat {
+		return -n.f, nil
+	}
+	if n.isUint {
+		return fitInt(operator, new(big.Int).Neg(n.bigInt()))
+	}
+	if n.i == math.MinInt64 {
+		return nil, RuntimeErrWithToken(operator, "integer overflow")
+	}
+	return -n.i, nil
+}
+
+// arithmetic computes ln operator rn. Two integers give an integer, "/"
+// being the integer division, otherwise both are converted to float64.
+func arithmetic(operator *Token, ln, rn number) (interface{}, error) {
+	if !ln.isFloat && !rn.isFloat {
+		if ln.isUint || rn.isUint {
+			return bigArithmetic(operator, ln.bigInt(), rn.bigInt())
+		}
+		return intArithmetic(operator, ln.i, rn.i)
+	}
+
+	l, r := ln.float(), rn.float()
+	switch operator.typ {
+	case TokenPlus:
+		return l + r, nil
+	case TokenMinus:
+		return l - r, nil
+	case TokenStar:
+		return l * r, nil
+	case TokenSlash:
+		if r == 0 {
+			return nil, RuntimeErrWithToken(operator, "division by zero")
+		}
+		return l / r, nil
+	case TokenPercent:
+		if r == 0 {
+			return nil, RuntimeErrWithToken(operator, "division by zero")
+		}
+		return math.Mod(l, r), nil
+	default:
+		return nil, RuntimeErrWithToken(operator, "unknown operator")
+	}
+}
+
+func intArithmetic(operator *Token, l, r int64) (interface{}, error) {
+	var res int64
+	var overflow bool
+
+	switch operator.typ {
+	case TokenPlus:
+		res = l + r
+		overflow = (r > 0 && res < l) || (r < 0 && res > l)
+	case TokenMinus:
+		res = l - r
+		overflow = (r > 0 && res > l) || (r < 0 && res < l)
+	case TokenStar:
+		res = l * r
+		overflow = l != 0 && (res/l != r || (l == -1 && r == math.MinInt64))
+	case TokenSlash:
+		if r == 0 {
+			return nil, RuntimeErrWithToken(operator, "division by zero")
+		}
+		res = l / r
+		overflow = l == math.MinInt64 && r == -1
+	case TokenPercent:
+		if r == 0 {
+			return nil, RuntimeErrWithToken(operator, "division by zero")
+		}
+		res = l % r
+	default:
+		return nil, RuntimeErrWithToken(operator, "unknown operator")
+	}
+
+	if overflow {
+		return nil, RuntimeErrWithToken(operator, "integer overflow")
+	}
+	return res, nil
+}
+
+// bigArithmetic computes l operator r for the integers beyond int64, giving
+// an int64 or, above math.MaxInt64, a uint64.
+func bigArithmetic(operator *Token, l, r *big.Int) (interface{}, error) {
+	res := new(big.Int)
+	switch operator.typ {
+	case TokenPlus:
+		res.Add(l, r)
+	case TokenMinus:
+		res.Sub(l, r)
+	case TokenStar:
+		res.Mul(l, r)
+	case TokenSlash, TokenPercent:
+		if r.Sign() == 0 {
+			return nil, RuntimeErrWithToken(operator, "division by zero")
+		}
+		if operator.typ == TokenSlash {
+			res.Quo(l, r)
+		} else {
+			res.Rem(l, r)
+		}
+	default:
+		return nil, RuntimeErrWithToken(operator, "unknown operator")
+	}
+	return fitInt(operator, res)
+}
+
+// fitInt returns n as an int64, or a uint64 above math.MaxInt64.
+func fitInt(operator *Token, n *big.Int) (interface{}, error) {
+	switch {
+	case n.IsInt64():
+		return n.Int64(), nil
+	case n.IsUint64():
+		return n.Uint64(), nil
+	default:
+		return nil, RuntimeErrWithToken(operator, "integer overflow")
+	}
+}
@@ -1,40 +1,149 @@
 package expr
 
 import (
-	"fmt"
 	"reflect"
 )
 
 /*
-expression     → equality ;
-equality       → comparison ( ( "!=" | "==" ) comparison )* ;
-comparison     → term ( ( ">" | ">=" | "<" | "<=" ) term )* ;
+expression     → conditional ;
+conditional    → or ( "?" expression ":" conditional )? ;
+or             → and ( "or" and )* ;
+and            → equality ( "and" equality )* ;
+equality       → membership ( ( "!=" | "==" ) membership )* ;
+membership     → comparison ( ( "in" | "not in" | "contains" | "contains_any"
+                 | "contains_all" | "subset_of" | "intersects" ) comparison )* ;
+comparison     → coalesce ( ( ">" | ">=" | "<" | "<=" ) coalesce )* ;
+coalesce       → term ( "??" coalesce )? ;
 term           → factor ( ( "-" | "+" ) factor )* ;
-factor         → unary ( ( "/" | "*" ) unary )* ;
+factor         → unary ( ( "/" | "*" | "%" ) unary )* ;
 unary          → ( "!" | "-" ) unary
-               | primary ;
+               | call ;
+call           → primary ( "(" arguments? ")" | ( "." | "?." ) IDENTIFIER
+                 | "[" expression "]" | "[" expression? ":" expression? "]" )* ;
 primary        → NUMBER | STRING | "true" | "false" | "nil"
                | "(" expression ")" ;
 */
 
 type Parser struct {
+	// Limits bounds the nesting depth and the length of list literals.
+	Limits Limits
+
 	tokens  []*Token
 	current int
+	depth   int
+	errors  ErrorList
 }
 
+// NewParser returns a parser of tokens. Comment tokens are skipped.
 func NewParser(tokens []*Token) *Parser {
-	return &Parser{tokens: tokens}
+	filtered := make([]*Token, 0, len(tokens))
+	for _, token := range tokens {
+		if token.typ != TokenComment {
+			filtered = append(filtered, token)
+		}
+	}
+	return &Parser{tokens: filtered}
 }
 
+// Parse parses the tokens into an expression. The parser recovers from
+// syntax errors, so it reports all of them at once as an ErrorList, along
+// with a partial expression in which the invalid parts are ExprBad nodes.
+//
+// Unexpected tokens after a complete expression, such as a stray ')', are
+// reported and skipped, and the rest of the tokens is parsed only to report
+// its errors: the partial expression is the one before them.
 func (p *Parser) Parse() (expr Expr, err error) {
-	return p.expression()
+	expr, err = p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	for !p.isAtEnd() {
+		p.add(p.Error(p.peek(), "Expect end of expression.").(*Error))
+		for !p.isAtEnd() && !p.startsExpression() {
+			p.advance()
+		}
+		if p.isAtEnd() {
+			break
+		}
+		if _, err := p.expression(); err != nil {
+			return nil, err
+		}
+	}
+
+	return expr, p.errors.Err()
 }
 
 func (p *Parser) expression() (Expr, error) {
-	return p.or()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	return p.conditional()
+}
+
+func (p *Parser) conditional() (Expr, error) {
+	start := p.current
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.match(TokenQuestion) {
+		question := p.previous()
+		then, err := p.delimited("Expect ':' after then branch of conditional expression.", TokenColon)
+		if err != nil {
+			return nil, err
+		}
+		if !p.match(TokenColon) {
+			return p.bad(start), nil
+		}
+
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		otherwise, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		expr = p.spanned(start, NewExprConditional(expr, question, then, otherwise))
+	}
+
+	return expr, nil
+}
+
+// spanned sets the span of expr, from the token at index start to the
+// previous token, and returns expr.
+func (p *Parser) spanned(start int, expr Expr) Expr {
+	to := p.tokens[start]
+	if p.current > start {
+		to = p.previous()
+	}
+	expr.setSpan(newSpan(p.tokens[start], to))
+	return expr
+}
+
+// enter increases the nesting depth, which is limited to keep the recursive
+// descent from exhausting the stack, by defaultParseDepth if Limits.MaxDepth
+// is zero.
+func (p *Parser) enter() error {
+	p.depth++
+	max := p.Limits.MaxDepth
+	if max == 0 {
+		max = defaultParseDepth
+	}
+	return LimitDepth.check(p.depth, max)
+}
+
+func (p *Parser) leave() {
+	p.depth--
 }
 
 func (p *Parser) or() (Expr, error) {
+	start := p.current
 	expr, err := p.and()
 	if err != nil {
 		return nil, err
@@ -46,211 +155,423 @@ func (p *Parser) or() (Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprLogical(expr, operator, right)
+		expr = p.spanned(start, NewExprLogical(expr, operator, right))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) and() (Expr, error) {
-	expr, err := p.equality()
+	start := p.current
+	expr, err := p.operand()
 	if err != nil {
 		return nil, err
 	}
 
 	for p.match(TokenAnd) {
 		operator := p.previous()
-		right, err := p.equality()
+		right, err := p.operand()
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprLogical(expr, operator, right)
+		expr = p.spanned(start, NewExprLogical(expr, operator, right))
 	}
 
 	return expr, nil
 }
 
+// operand parses an operand of "and" or "or". On a syntax error it records
+// the error and skips to the next "and", "or", "?" or ":", returning an
+// ExprBad in place of the operand so that parsing can go on.
+func (p *Parser) operand() (Expr, error) {
+	start := p.current
+	expr, err := p.equality()
+	if err == nil {
+		return expr, nil
+	}
+	if err := p.record(err); err != nil {
+		return nil, err
+	}
+
+	p.skipTo(TokenAnd, TokenOr, TokenQuestion, TokenColon)
+	return p.bad(start), nil
+}
+
+// delimited parses an expression followed by one of delimiters, which is
+// left to the caller. On a syntax error it records the error and skips to
+// the next delimiter, returning an ExprBad in place of the expression, so
+// that the constructs between delimiters report their errors one by one.
+//
+// After a recovery the next token may still not be one of delimiters, e.g.
+// at the end of the tokens: the caller then gives up its construct, the
+// error being already recorded.
+func (p *Parser) delimited(msg string, delimiters ...TokenType) (Expr, error) {
+	start := p.current
+	expr, err := p.expression()
+	if err == nil {
+		if p.check(delimiters...) {
+			return expr, nil
+		}
+		err = p.Error(p.peek(), msg)
+	}
+	if err := p.record(err); err != nil {
+		return nil, err
+	}
+
+	p.skipTo(delimiters...)
+	return p.bad(start), nil
+}
+
+// record records a syntax error, returning the other errors, such as a
+// LimitError, which stop the parsing.
+func (p *Parser) record(err error) error {
+	e, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	p.add(e)
+	return nil
+}
+
+// add adds e to the errors unless the previous error is at the same
+// position: a construct missing its closing token fails in each of the
+// enclosing ones, and only the innermost error is meaningful.
+func (p *Parser) add(e *Error) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Start == e.Start {
+		return
+	}
+	p.errors = append(p.errors, e)
+}
+
+// bad returns an ExprBad from the token at index start to the previous
+// token.
+func (p *Parser) bad(start int) Expr {
+	to := p.tokens[start]
+	if p.current > start {
+		to = p.previous()
+	}
+	return p.spanned(start, NewExprBad(p.tokens[start], to))
+}
+
+// skipTo skips the tokens up to one of types, a ',' or a ')' or ']' closing
+// an enclosing construct. The tokens between parentheses or brackets are
+// skipped as a whole.
+func (p *Parser) skipTo(types ...TokenType) {
+	depth := 0
+	for !p.isAtEnd() {
+		switch p.peek().typ {
+		case TokenLeftParen, TokenLeftBracket:
+			depth++
+		case TokenRightParen, TokenRightBracket:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case TokenComma:
+			if depth == 0 {
+				return
+			}
+		default:
+			if depth == 0 && p.check(types...) {
+				return
+			}
+		}
+		p.advance()
+	}
+}
+
+// startsExpression reports whether the next token can start an expression.
+func (p *Parser) startsExpression() bool {
+	switch p.peek().typ {
+	case TokenBang, TokenMinus, TokenNumber, TokenString, TokenTrue, TokenFalse, TokenNil,
+		TokenIdentifier, TokenLeftParen, TokenLeftBracket:
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *Parser) equality() (Expr, error) {
-	expr, err := p.comparison()
+	start := p.current
+	expr, err := p.membership()
 	if err != nil {
 		return nil, err
 	}
 
 	for p.match(TokenBangEqual, TokenEqualEqual) {
+		var operator = p.previous()
+		right, err := p.membership()
+		if err != nil {
+			return nil, err
+		}
+		expr = p.spanned(start, NewExprBinary(expr, operator, right))
+	}
+
+	return expr, nil
+}
+
+func (p *Parser) membership() (Expr, error) {
+	start := p.current
+	expr, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.match(TokenIn, TokenNotIn, TokenContains,
+		TokenContainsAny, TokenContainsAll, TokenSubsetOf, TokenIntersects) {
 		var operator = p.previous()
 		right, err := p.comparison()
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprBinary(expr, operator, right)
+		expr = p.spanned(start, NewExprBinary(expr, operator, right))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) comparison() (Expr, error) {
-	// 按完整的实现，下一级应该是term
-	nextLevel := p.unary
-
-	expr, err := nextLevel()
+	start := p.current
+	expr, err := p.coalesce()
 	if err != nil {
 		return nil, err
 	}
 
 	for p.match(TokenGreater, TokenGreaterEqual, TokenLess, TokenLessEqual) {
 		var operator = p.previous()
-		right, err := nextLevel()
+		right, err := p.coalesce()
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprBinary(expr, operator, right)
+		expr = p.spanned(start, NewExprBinary(expr, operator, right))
+	}
+
+	return expr, nil
+}
+
+// coalesce is right associative: a ?? b ?? c is a ?? (b ?? c).
+func (p *Parser) coalesce() (Expr, error) {
+	start := p.current
+	expr, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.match(TokenQuestionQuestion) {
+		var operator = p.previous()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		right, err := p.coalesce()
+		if err != nil {
+			return nil, err
+		}
+		expr = p.spanned(start, NewExprLogical(expr, operator, right))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) term() (Expr, error) {
+	start := p.current
 	expr, err := p.factor()
 	if err != nil {
 		return nil, err
 	}
-	for p.match() { // MINUS, PLUS
+	for p.match(TokenMinus, TokenPlus) {
 		var operator = p.previous()
 		right, err := p.factor()
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprBinary(expr, operator, right)
+		expr = p.spanned(start, NewExprBinary(expr, operator, right))
 	}
 	return expr, nil
 }
 
 func (p *Parser) factor() (Expr, error) {
+	start := p.current
 	expr, err := p.unary()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.match() { // SLASH, STAR
+	for p.match(TokenSlash, TokenStar, TokenPercent) {
 		var operator = p.previous()
 		right, err := p.unary()
 		if err != nil {
 			return nil, err
 		}
-		expr = NewExprBinary(expr, operator, right)
+		expr = p.spanned(start, NewExprBinary(expr, operator, right))
 	}
 
 	return expr, nil
 }
 
 func (p *Parser) unary() (Expr, error) {
+	start := p.current
 	if p.match(TokenBang, TokenMinus) {
 		var operator = p.previous()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
 		right, err := p.unary()
 		if err != nil {
 			return nil, err
 		}
-		return NewExprUnary(operator, right), nil
+		return p.spanned(start, NewExprUnary(operator, right)), nil
 	}
 
 	return p.call()
 }
 
 func (p *Parser) call() (Expr, error) {
+	start := p.current
 	expr, err := p.primary()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.match(TokenLeftParen) {
-		expr, err = p.finishCall(expr)
-		if err != nil {
-			return nil, err
+	for {
+		if p.match(TokenLeftParen) {
+			expr, err = p.finishCall(start, expr)
+			if err != nil {
+				return nil, err
+			}
+		} else if p.match(TokenDot, TokenQuestionDot) {
+			optional := p.previous().typ == TokenQuestionDot
+			name, err := p.consume(TokenIdentifier, "Expect property name after '.'.")
+			if err != nil {
+				return nil, err
+			}
+			expr = p.spanned(start, NewExprGet(expr, name, optional))
+		} else if p.match(TokenLeftBracket) {
+			expr, err = p.finishIndex(start, expr)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			break
 		}
 	}
 
 	return expr, nil
 }
 
-func (p *Parser) finishCall(callee Expr) (Expr, error) {
+// finishCall parses the arguments of a call. A broken argument becomes an
+// ExprBad, and the call itself if its ')' is missing.
+func (p *Parser) finishCall(start int, callee Expr) (Expr, error) {
 	var arguments []Expr
 	if !p.check(TokenRightParen) {
-		e, err := p.expression()
-		if err != nil {
-			return nil, err
-		}
-		arguments = append(arguments, e)
-		for p.match(TokenComma) {
+		for {
 			// TODO: limit arguments size
-			e, err := p.expression()
+			e, err := p.delimited("Expect ')' after arguments.", TokenComma, TokenRightParen)
 			if err != nil {
 				return nil, err
 			}
 			arguments = append(arguments, e)
+			if !p.match(TokenComma) {
+				break
+			}
 		}
 	}
-	paren, err := p.consume(TokenRightParen, "Expect ')' after arguments.")
-	if err != nil {
-		return nil, err
+	if !p.match(TokenRightParen) {
+		return p.bad(start), nil
 	}
 
-	return NewExprCall(callee, paren, arguments), nil
+	return p.spanned(start, NewExprCall(callee, p.previous(), arguments)), nil
 }
 
-func (p *Parser) finishArray() (Expr, error) {
-	var items []Expr
-	if !p.check(TokenRightBracket) {
-		e, err := p.expression()
+func (p *Parser) finishIndex(start int, object Expr) (Expr, error) {
+	var index, end Expr
+	var colon *Token
+	var err error
+
+	if !p.check(TokenColon) {
+		index, err = p.delimited("Expect ']' after index.", TokenColon, TokenRightBracket)
 		if err != nil {
 			return nil, err
 		}
-		items = append(items, e)
-		for p.match(TokenComma) {
-			e, err := p.expression()
+	}
+	if p.match(TokenColon) {
+		colon = p.previous()
+		if !p.check(TokenRightBracket) {
+			end, err = p.delimited("Expect ']' after index.", TokenRightBracket)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !p.match(TokenRightBracket) {
+		return p.bad(start), nil
+	}
+
+	return p.spanned(start, NewExprIndex(object, p.previous(), index, colon, end)), nil
+}
+
+func (p *Parser) finishArray(start int) (Expr, error) {
+	var items []Expr
+	if !p.check(TokenRightBracket) {
+		for {
+			e, err := p.delimited("expect ']'", TokenComma, TokenRightBracket)
 			if err != nil {
 				return nil, err
 			}
 			items = append(items, e)
+			if !p.match(TokenComma) {
+				break
+			}
 		}
 	}
-	bracket, err := p.consume(TokenRightBracket, "expect ']'")
-	if err != nil {
+	if !p.match(TokenRightBracket) {
+		return p.bad(start), nil
+	}
+	bracket := p.previous()
+	if err := LimitListLength.check(len(items), p.Limits.MaxListLength); err != nil {
 		return nil, err
 	}
 
-	return NewExprArray(bracket, items), nil
+	return p.spanned(start, NewExprArray(bracket, items)), nil
 }
 
 func (p *Parser) primary() (Expr, error) {
+	start := p.current
 	if p.match(TokenFalse) {
-		return NewExprLiteral(false, reflect.Bool), nil
+		return p.spanned(start, NewExprLiteral(false, reflect.Bool)), nil
 	}
 	if p.match(TokenTrue) {
-		return NewExprLiteral(true, reflect.Bool), nil
+		return p.spanned(start, NewExprLiteral(true, reflect.Bool)), nil
+	}
+	if p.match(TokenNil) {
+		return p.spanned(start, NewExprLiteral(nil, reflect.Invalid)), nil
 	}
 	if p.match(TokenNumber) {
-		return NewExprLiteral(p.previous().literal, reflect.Float64), nil
+		literal := p.previous().literal
+		return p.spanned(start, NewExprLiteral(literal, reflect.TypeOf(literal).Kind())), nil
 	}
 	if p.match(TokenString) {
-		return NewExprLiteral(p.previous().literal, reflect.String), nil
+		return p.spanned(start, NewExprLiteral(p.previous().literal, reflect.String)), nil
 	}
 	if p.match(TokenIdentifier) {
-		return NewExprVariable(p.previous()), nil
+		return p.spanned(start, NewExprVariable(p.previous())), nil
 	}
 	if p.match(TokenLeftParen) {
-		expr, err := p.expression()
+		expr, err := p.delimited("Expect ')' after expression.", TokenRightParen)
 		if err != nil {
 			return nil, err
 		}
-		_, err = p.consume(TokenRightParen, "Expect ')' after expression.")
-		if err != nil {
-			return nil, err
+		if !p.match(TokenRightParen) {
+			return p.bad(start), nil
 		}
-		return NewExprGrouping(expr), nil
+		return p.spanned(start, NewExprGrouping(expr)), nil
 	}
 
 	if p.match(TokenLeftBracket) {
-		expr, err := p.finishArray()
+		expr, err := p.finishArray(start)
 		if err != nil {
 			return nil, err
 		}
@@ -270,11 +591,17 @@ func (p *Parser) match(types ...TokenType) bool {
 	return false
 }
 
-func (p *Parser) check(t TokenType) bool {
+// check reports whether the next token is of one of types.
+func (p *Parser) check(types ...TokenType) bool {
 	if p.isAtEnd() {
 		return false
 	}
-	return p.peek().typ == t
+	for _, t := range types {
+		if p.peek().typ == t {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) isAtEnd() bool {
@@ -302,22 +629,9 @@ func (p *Parser) consume(typ TokenType, msg string) (*Token, error) {
 	return nil, p.Error(p.peek(), msg)
 }
 
-func (p *Parser) Error(token *Token, msg string) ParseError {
-	var s string
+func (p *Parser) Error(token *Token, msg string) error {
 	if token.typ == TokenEOF {
-		s = report(token.line, " at end", msg)
-	} else {
-		s = report(token.line, " at '"+token.lexeme+"'", msg)
+		return newError(KindParse, token, "at end: "+msg)
 	}
-	return ParseError(s)
-}
-
-func report(line int, where string, msg string) string {
-	return fmt.Sprintf("[line %d ] %s: %s\n", line, where, msg)
-}
-
-type ParseError string
-
-func (p ParseError) Error() string {
-	return string(p)
+	return newError(KindParse, token, "at '"+token.lexeme+"': "+msg)
 }
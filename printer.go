@@ -49,6 +49,36 @@ func (p *AstPrinter) VisitExprArrayStr(expr *ExprArray) string {
 	return p.block(expr.bracket.lexeme, "[", "]", expr.items...)
 }
 
+func (p *AstPrinter) VisitExprGetStr(expr *ExprGet) string {
+	operator := "."
+	if expr.optional {
+		operator = "?."
+	}
+	return fmt.Sprintf("(%s %s %s)", operator, expr.object.AcceptStr(p), expr.name.lexeme)
+}
+
+func (p *AstPrinter) VisitExprIndexStr(expr *ExprIndex) string {
+	if expr.colon == nil {
+		return p.block("index", "(", ")", expr.object, expr.index)
+	}
+
+	bound := func(e Expr) string {
+		if e == nil {
+			return "nil"
+		}
+		return e.AcceptStr(p)
+	}
+	return fmt.Sprintf("(slice %s %s %s)", expr.object.AcceptStr(p), bound(expr.index), bound(expr.end))
+}
+
+func (p *AstPrinter) VisitExprConditionalStr(expr *ExprConditional) string {
+	return p.block("?", "(", ")", expr.condition, expr.then, expr.otherwise)
+}
+
+func (p *AstPrinter) VisitExprBadStr(expr *ExprBad) string {
+	return "(bad)"
+}
+
 func (p *AstPrinter) block(name, start, end string, exprs ...Expr) string {
 	var builder strings.Builder
 
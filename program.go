@@ -0,0 +1,175 @@
+package expr
+
+import "context"
+
+// Program is a compiled expression. It is scanned and parsed once by Compile
+// and can then be evaluated many times, from many goroutines at once.
+type Program struct {
+	expr   Expr
+	env    *Environment
+	limits Limits
+
+	scannerOpts []ScannerOption
+	schema      Schema
+	symbols     []string
+	resolve     bool
+}
+
+// Option configures a Program when it is compiled.
+type Option func(p *Program) error
+
+// WithEnvironment makes the symbols defined in env available to the program.
+// env must not be modified while the program is being evaluated.
+//
+// A program has a single such environment: the last WithEnvironment wins,
+// the environments of the previous ones being ignored. The symbols of
+// several environments are combined by defining them in one of them or in
+// an environment enclosed by another, see NewEnclosedEnvironment.
+func WithEnvironment(env *Environment) Option {
+	return func(p *Program) error {
+		p.env.enclosing = env
+		return nil
+	}
+}
+
+// WithFunc registers a Go function, see Environment.DefineGoFunc.
+func WithFunc(name string, f interface{}) Option {
+	return func(p *Program) error {
+		return p.env.DefineGoFunc(name, f)
+	}
+}
+
+// WithLimits bounds the resources used to compile and evaluate the program.
+func WithLimits(limits Limits) Option {
+	return func(p *Program) error {
+		p.limits = limits
+		return nil
+	}
+}
+
+// WithScannerOptions configures the scanner, e.g. to accept ChineseKeywords.
+func WithScannerOptions(opts ...ScannerOption) Option {
+	return func(p *Program) error {
+		p.scannerOpts = append(p.scannerOpts, opts...)
+		return nil
+	}
+}
+
+// WithSchema makes Compile check the types of the expression, see Check.
+// schema gives the types of the variables, the functions registered in the
+// program's environment are added to it.
+func WithSchema(schema Schema) Option {
+	return func(p *Program) error {
+		p.schema = schema
+		return nil
+	}
+}
+
+// WithSymbols declares the names of the variables passed to Eval, so that
+// Compile reports the undefined variables and functions, see Resolve. The
+// functions registered in the program's environment and the variables of
+// the schema given WithSchema are defined as well.
+func WithSymbols(names ...string) Option {
+	return func(p *Program) error {
+		p.symbols = append(p.symbols, names...)
+		p.resolve = true
+		return nil
+	}
+}
+
+// Compile scans and parses src into a Program.
+func Compile(src string, opts ...Option) (*Program, error) {
+	p := &Program{
+		env: NewEnvironment(),
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+
+	scanner := NewScanner(src, p.scannerOpts...)
+	scanner.Limits = p.limits
+	tokens, scanErr := scanner.ScanTokens()
+	if _, ok := scanErr.(ErrorList); scanErr != nil && !ok {
+		return nil, scanErr
+	}
+
+	// parse even if there are scan errors to report the syntax errors too
+	parser := NewParser(tokens)
+	parser.Limits = p.limits
+	expr, parseErr := parser.Parse()
+	if _, ok := parseErr.(ErrorList); parseErr != nil && !ok {
+		return nil, parseErr
+	}
+
+	if scanErr != nil || parseErr != nil {
+		var errs ErrorList
+		if scanErr != nil {
+			errs = append(errs, scanErr.(ErrorList)...)
+		}
+		if parseErr != nil {
+			errs = append(errs, parseErr.(ErrorList)...)
+		}
+		errs.Sort()
+		return nil, errs
+	}
+
+	// the parser does not count the chains, so check the depth the
+	// interpreter will reach to reject the programs Eval would fail
+	if p.limits.MaxDepth > 0 {
+		if err := LimitDepth.check(depth(expr), p.limits.MaxDepth); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.resolve {
+		names := append(p.env.Names(), p.symbols...)
+		for name := range p.schema {
+			names = append(names, name)
+		}
+		if err := Resolve(expr, names); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.schema != nil {
+		schema := p.env.Schema()
+		for name, t := range p.schema {
+			schema[name] = t
+		}
+		if err := Check(expr, schema); err != nil {
+			return nil, err
+		}
+	}
+
+	p.expr = expr
+	return p, nil
+}
+
+// Expr returns the parsed expression, e.g. to inspect it with Inspect.
+func (p *Program) Expr() Expr {
+	return p.expr
+}
+
+// Eval evaluates the program with vars defined as variables. Each call gets
+// its own Environment enclosed by the program's, so Eval is safe for
+// concurrent use.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.EvalContext(context.Background(), vars)
+}
+
+// EvalContext is like Eval, but stops the evaluation once ctx is done, see
+// Interpreter.InterpretContext.
+func (p *Program) EvalContext(ctx context.Context, vars map[string]interface{}) (interface{}, error) {
+	env := NewEnclosedEnvironment(p.env)
+	for name, value := range vars {
+		env.Define(name, value)
+	}
+
+	interpreter := &Interpreter{
+		Environment: env,
+		Limits:      p.limits,
+	}
+	return interpreter.InterpretContext(ctx, p.expr)
+}
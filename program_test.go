@@ -0,0 +1,321 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_program(t *testing.T) {
+	ner := func(doc map[string]interface{}, name string) interface{} {
+		return doc[name]
+	}
+	program, err := Compile(`"补水" in ner(doc, "功效") and price * 0.8 < limit`,
+		WithFunc("ner", ner))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		vars   map[string]interface{}
+		expect bool
+	}{
+		{vars: map[string]interface{}{
+			"doc":   map[string]interface{}{"功效": []string{"补水"}},
+			"price": 100,
+			"limit": 100,
+		}, expect: true},
+		{vars: map[string]interface{}{
+			"doc":   map[string]interface{}{"功效": []string{"抗皱"}},
+			"price": 100,
+			"limit": 100,
+		}, expect: false},
+		{vars: map[string]interface{}{
+			"doc":   map[string]interface{}{"功效": []string{"补水"}},
+			"price": 200,
+			"limit": 100,
+		}, expect: false},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100*len(testCases))
+	for i := 0; i < 100; i++ {
+		for _, tc := range testCases {
+			wg.Add(1)
+			go func(vars map[string]interface{}, expect bool) {
+				defer wg.Done()
+
+				res, err := program.Eval(vars)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if res != expect {
+					errs <- fmt.Errorf("expect %v, got %v", expect, res)
+				}
+			}(tc.vars, tc.expect)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func Test_program_vars_isolated(t *testing.T) {
+	env := NewEnvironment()
+	env.Define("threshold", 10)
+
+	program, err := Compile(`x > threshold`, WithEnvironment(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := program.Eval(map[string]interface{}{"x": 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != true {
+		t.Fatalf("expect true, got %v", res)
+	}
+
+	if _, err := program.Eval(nil); err == nil {
+		t.Fatal("variable x should not leak into the next evaluation")
+	}
+
+	if _, err := env.Get(NewToken(TokenIdentifier, "x", nil, 1)); err == nil {
+		t.Fatal("variable x should not be defined in the shared environment")
+	}
+}
+
+func Test_program_environments(t *testing.T) {
+	first := NewEnvironment()
+	first.Define("a", 1)
+	second := NewEnvironment()
+	second.Define("b", 2)
+
+	// the last environment wins
+	program, err := Compile(`b == 2`, WithEnvironment(first), WithEnvironment(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := program.Eval(nil); err != nil || res != true {
+		t.Fatalf("expect true, got %v, %v", res, err)
+	}
+	program, err = Compile(`a == 1`, WithEnvironment(first), WithEnvironment(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Eval(nil); err == nil {
+		t.Fatal("want error for a, which is in the ignored environment")
+	}
+
+	// an enclosed environment combines them
+	both := NewEnclosedEnvironment(first)
+	both.Define("b", 2)
+	program, err = Compile(`a == 1 and b == 2`, WithEnvironment(both))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := program.Eval(nil); err != nil || res != true {
+		t.Fatalf("expect true, got %v, %v", res, err)
+	}
+}
+
+func Test_compile_error(t *testing.T) {
+	if _, err := Compile(`1 +`); err == nil {
+		t.Fatal("want parse error")
+	}
+
+	if _, err := Compile(`1`, WithFunc("f", 1)); err == nil {
+		t.Fatal("want error for non-function")
+	}
+}
+
+type (
+	testContextKey struct{}
+	testCancelKey  struct{}
+)
+
+func Test_program_context(t *testing.T) {
+	called := 0
+	program, err := Compile(`request_id() == "r1" and stop() and count()`,
+		WithFunc("request_id", func(ctx context.Context) string {
+			return ctx.Value(testContextKey{}).(string)
+		}),
+		WithFunc("stop", func(ctx context.Context) bool {
+			cancel := ctx.Value(testCancelKey{}).(context.CancelFunc)
+			cancel()
+			return true
+		}),
+		WithFunc("count", func() bool {
+			called++
+			return true
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, testContextKey{}, "r1")
+	ctx = context.WithValue(ctx, testCancelKey{}, cancel)
+
+	_, err = program.EvalContext(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if called != 0 {
+		t.Fatalf("want evaluation stopped after cancel, count called %d times", called)
+	}
+
+	_, err = program.EvalContext(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled for done context, got %v", err)
+	}
+}
+
+func Test_program_deadline(t *testing.T) {
+	slow := func(ctx context.Context, name string) ([]string, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return []string{name}, nil
+		}
+	}
+	program, err := Compile(`"补水" in ner_entities("功效")`, WithFunc("ner_entities", slow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = program.EvalContext(ctx, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+
+	var callErr *CallError
+	if !errors.As(err, &callErr) || callErr.Name != "ner_entities" {
+		t.Fatalf("want CallError from ner_entities, got %v", err)
+	}
+}
+
+func Test_context_arity(t *testing.T) {
+	env := NewEnvironment()
+	if err := env.DefineGoFunc("f", func(ctx context.Context, a string, b ...int) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := env.Get(NewToken(TokenIdentifier, "f", nil, 1))
+	f := v.(*GoFunc)
+	if min, max := f.Arity(); min != 1 || max != -1 {
+		t.Fatalf("want arity [1, -1], got [%d, %d]", min, max)
+	}
+	if f.String() != "f(string, ...int) bool" {
+		t.Fatalf("unexpected signature %s", f)
+	}
+}
+
+func Test_limits(t *testing.T) {
+	entities := func() []string {
+		return []string{"a", "b", "c"}
+	}
+	yes := func() bool {
+		return true
+	}
+
+	testCases := []struct {
+		name   string
+		src    string
+		limits Limits
+		target error
+		// atCompile is set if Compile reports the limit
+		atCompile bool
+	}{
+		{name: "source", src: `1 == 1`, limits: Limits{MaxSourceLength: 5}, target: ErrSourceTooLong, atCompile: true},
+		{name: "tokens", src: `1 == 1`, limits: Limits{MaxTokens: 2}, target: ErrTooManyTokens, atCompile: true},
+		{name: "nested groups", src: strings.Repeat("(", 500) + "1" + strings.Repeat(")", 500),
+			limits: Limits{MaxDepth: 100}, target: ErrTooDeep, atCompile: true},
+		{name: "nested unary", src: strings.Repeat("!", 500) + "true",
+			limits: Limits{MaxDepth: 100}, target: ErrTooDeep, atCompile: true},
+		{name: "nested coalesce", src: strings.Repeat("nil ?? ", 500) + "1",
+			limits: Limits{MaxDepth: 100}, target: ErrTooDeep, atCompile: true},
+		{name: "long chain", src: "1" + strings.Repeat(" + 1", 1000) + " > 0",
+			limits: Limits{MaxDepth: 100}, target: ErrTooDeep, atCompile: true},
+		{name: "long or chain", src: "true" + strings.Repeat(" or false", 20),
+			limits: Limits{MaxDepth: 10}, target: ErrTooDeep, atCompile: true},
+		{name: "steps", src: `1 + 1 + 1 > 0`, limits: Limits{MaxSteps: 5}, target: ErrTooManySteps},
+		{name: "calls", src: `yes() and yes() and yes()`, limits: Limits{MaxCalls: 2}, target: ErrTooManyCalls},
+		{name: "list literal", src: `[1, 2, 3] == []`, limits: Limits{MaxListLength: 2}, target: ErrListTooLong, atCompile: true},
+		{name: "list result", src: `entities() == []`, limits: Limits{MaxListLength: 2}, target: ErrListTooLong},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []Option{
+				WithFunc("entities", entities),
+				WithFunc("yes", yes),
+			}
+
+			// succeeds without limits
+			program, err := Compile(tc.src, opts...)
+			if err != nil {
+				t.Fatalf("compile failed: %s", err)
+			}
+			if _, err := program.Eval(nil); err != nil {
+				t.Fatalf("eval failed: %s", err)
+			}
+
+			program, err = Compile(tc.src, append(opts, WithLimits(tc.limits))...)
+			if (err != nil) != tc.atCompile {
+				t.Fatalf("want limit reported by Compile: %v, got %v", tc.atCompile, err)
+			}
+			if err == nil {
+				_, err = program.Eval(nil)
+			}
+			if !errors.Is(err, tc.target) {
+				t.Fatalf("want %v, got %v", tc.target, err)
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Max == 0 {
+				t.Fatalf("want LimitError with max, got %v", err)
+			}
+			for _, other := range []error{ErrSourceTooLong, ErrTooManyTokens, ErrTooDeep,
+				ErrTooManySteps, ErrTooManyCalls, ErrListTooLong} {
+				if other != tc.target && errors.Is(err, other) {
+					t.Fatalf("%v should not match %v", err, other)
+				}
+			}
+		})
+	}
+}
+
+// Test_limits_default_depth checks that the parser limits its recursion even
+// without limits, since a stack overflow kills the process.
+func Test_limits_default_depth(t *testing.T) {
+	deep := strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000)
+	_, err := Compile(deep)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitDepth {
+		t.Fatalf("want LimitError, got %v", err)
+	}
+
+	// the limit can be raised
+	src := strings.Repeat("(", 2000) + "1" + strings.Repeat(")", 2000)
+	if _, err := Compile(src); !errors.Is(err, ErrTooDeep) {
+		t.Fatalf("want ErrTooDeep, got %v", err)
+	}
+	if _, err := Compile(src, WithLimits(Limits{MaxDepth: 3000})); err != nil {
+		t.Fatalf("compile failed: %s", err)
+	}
+}
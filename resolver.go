@@ -0,0 +1,163 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Resolve reports the variables and functions of expr which are not in
+// names, in all the branches of expr, including the ones the evaluation
+// would skip. The names of an Environment are given by its Names method.
+//
+// All the errors, of KindResolve, are returned at once as an ErrorList,
+// suggesting the closest defined name when there is one.
+func Resolve(expr Expr, names []string) error {
+	r := &resolver{names: make(map[string]bool, len(names))}
+	for _, name := range names {
+		r.names[name] = true
+	}
+	r.resolve(expr)
+	return r.errors.Err()
+}
+
+// resolver is a visitor checking that the symbols are defined.
+type resolver struct {
+	names  map[string]bool
+	errors ErrorList
+}
+
+var _ ExprVisitorObj = (*resolver)(nil)
+
+func (r *resolver) resolve(exprs ...Expr) {
+	for _, expr := range exprs {
+		if expr != nil {
+			_, _ = expr.AcceptObj(r)
+		}
+	}
+}
+
+func (r *resolver) define(name *Token, what string) {
+	if r.names[name.lexeme] {
+		return
+	}
+
+	msg := fmt.Sprintf("undefined %s %s", what, name.lexeme)
+	if suggestion := r.suggest(name.lexeme); suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %s?", suggestion)
+	}
+	r.errors = append(r.errors, newError(KindResolve, name, msg))
+}
+
+// suggest returns the defined name closest to name, or "" if none is close
+// enough to be a likely typo.
+func (r *resolver) suggest(name string) string {
+	names := make([]string, 0, len(r.names))
+	for n := range r.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", len([]rune(name))/3+1
+	for _, n := range names {
+		if d := editDistance(name, n); d <= bestDistance && (best == "" || d < bestDistance) {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, counted in
+// characters.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur := row[j]
+			row[j] = minInt(minInt(row[j]+1, row[j-1]+1), prev+cost)
+			prev = cur
+		}
+	}
+	return row[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (r *resolver) VisitExprVariableObj(expr *ExprVariable) (interface{}, error) {
+	r.define(expr.name, "symbol")
+	return nil, nil
+}
+
+func (r *resolver) VisitExprCallObj(expr *ExprCall) (interface{}, error) {
+	if variable, ok := expr.callee.(*ExprVariable); ok {
+		r.define(variable.name, "function")
+	} else {
+		r.resolve(expr.callee)
+	}
+	r.resolve(expr.arguments...)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprLiteralObj(expr *ExprLiteral) (interface{}, error) {
+	return nil, nil
+}
+
+func (r *resolver) VisitExprGroupingObj(expr *ExprGrouping) (interface{}, error) {
+	r.resolve(expr.expression)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprUnaryObj(expr *ExprUnary) (interface{}, error) {
+	r.resolve(expr.right)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprBinaryObj(expr *ExprBinary) (interface{}, error) {
+	r.resolve(expr.left, expr.right)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprLogicalObj(expr *ExprLogical) (interface{}, error) {
+	r.resolve(expr.left, expr.right)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprArrayObj(expr *ExprArray) (interface{}, error) {
+	r.resolve(expr.items...)
+	return nil, nil
+}
+
+// VisitExprGetObj does not resolve the member name, which is not a symbol.
+func (r *resolver) VisitExprGetObj(expr *ExprGet) (interface{}, error) {
+	r.resolve(expr.object)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprIndexObj(expr *ExprIndex) (interface{}, error) {
+	r.resolve(expr.object, expr.index, expr.end)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprConditionalObj(expr *ExprConditional) (interface{}, error) {
+	r.resolve(expr.condition, expr.then, expr.otherwise)
+	return nil, nil
+}
+
+func (r *resolver) VisitExprBadObj(expr *ExprBad) (interface{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_resolve(t *testing.T) {
+	env := NewEnvironment()
+	if err := env.DefineGoFunc("ner_entities", func(name string) []string { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	env.Define("price", 10)
+	inner := NewEnclosedEnvironment(env)
+	inner.Define("user", nil)
+	inner.Define("功效", nil)
+
+	if names := inner.Names(); !reflect.DeepEqual(names, []string{"ner_entities", "price", "user", "功效"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+
+	testCases := []struct {
+		src    string
+		expect []string
+	}{
+		{src: `ner_entities("肤质") == [] or price > 1 and user.prise ?? 功效`},
+		{src: `true or ner_entites("肤质") == prise`, expect: []string{
+			"[line 1:9] undefined function ner_entites, did you mean ner_entities?",
+			"[line 1:30] undefined symbol prise, did you mean price?",
+		}},
+		{src: `false and (x ? users[0] : foo(功能))`, expect: []string{
+			"[line 1:12] undefined symbol x",
+			"[line 1:16] undefined symbol users, did you mean user?",
+			"[line 1:27] undefined function foo",
+			"[line 1:31] undefined symbol 功能, did you mean 功效?",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := toExpr(tc.src)
+			if err != nil {
+				t.Fatalf("parse expr failed: %s", err)
+			}
+
+			err = Resolve(e, inner.Names())
+			var got []string
+			var errs ErrorList
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					if e.Kind != KindResolve {
+						t.Fatalf("want resolve error, got %s", e.Kind)
+					}
+					got = append(got, e.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("want ErrorList, got %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Fatalf("want errors\n%s\ngot\n%s", strings.Join(tc.expect, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func Test_compile_symbols(t *testing.T) {
+	opts := []Option{
+		WithFunc("ner_entities", func(name string) []string { return nil }),
+		WithSymbols("price"),
+	}
+
+	program, err := Compile(`price > 1 or ner_entities("a") == []`, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := program.Eval(map[string]interface{}{"price": 2}); err != nil || res != true {
+		t.Fatalf("want true, got %v %v", res, err)
+	}
+
+	_, err = Compile(`price > 1 or ner_entity("a") == []`, opts...)
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindResolve || e.Msg != "undefined function ner_entity, did you mean ner_entities?" {
+		t.Fatalf("want resolve error, got %v", err)
+	}
+
+	if _, err := Compile(`x`); err != nil {
+		t.Fatalf("want no resolution without WithSymbols, got %v", err)
+	}
+	if _, err := Compile(`x`, WithSymbols()); err == nil {
+		t.Fatal("want undefined symbol x")
+	}
+}
@@ -2,51 +2,174 @@ package expr
 
 import (
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf16"
 )
 
-var keywords = map[string]TokenType{
-	"and":   TokenAnd,
-	"nil":   TokenNil,
-	"or":    TokenOr,
-	"true":  TokenTrue,
-	"false": TokenFalse,
+// Keywords maps the spellings of keywords and operators to their token
+// types. Entries starting with a letter are scanned as words, the others
+// as symbols.
+type Keywords map[string]TokenType
+
+// DefaultKeywords are the keywords of the language.
+var DefaultKeywords = Keywords{
+	"and":          TokenAnd,
+	"nil":          TokenNil,
+	"or":           TokenOr,
+	"true":         TokenTrue,
+	"false":        TokenFalse,
+	"in":           TokenIn,
+	"contains":     TokenContains,
+	"contains_any": TokenContainsAny,
+	"contains_all": TokenContainsAll,
+	"subset_of":    TokenSubsetOf,
+	"intersects":   TokenIntersects,
 }
 
+// OperatorAliases are alternative spellings of operators, accepted by
+// default.
+var OperatorAliases = Keywords{
+	"&&":  TokenAnd,
+	"||":  TokenOr,
+	"not": TokenBang,
+	"=":   TokenEqualEqual,
+	"<>":  TokenBangEqual,
+}
+
+// ChineseKeywords are Chinese spellings of operators, accepted by a Scanner
+// created WithKeywords(DefaultKeywords, OperatorAliases, ChineseKeywords).
+// They must be separated from identifiers by whitespace or punctuation.
+var ChineseKeywords = Keywords{
+	"且":  TokenAnd,
+	"或":  TokenOr,
+	"非":  TokenBang,
+	"包含": TokenContains,
+}
+
+// operators are the built-in operators longer than one character, which
+// take precedence over shorter symbol aliases.
+var operators = []string{"!=", "==", ">=", "<=", "??", "?.", "//", "/*"}
+
 type Scanner struct {
+	// Limits bounds the source length and the number of tokens.
+	Limits Limits
+
 	src    []rune
 	tokens []*Token
+	errors ErrorList
+	// offsets[i] is the byte offset of src[i], offsets[len(src)] is the
+	// length of the source in bytes.
+	offsets []int
 
 	start, current int
 	line           int
+	// lineStart is the index of the first character of the current line.
+	lineStart int
+	// startLine and startColumn are the position of the current lexeme.
+	startLine, startColumn int
+
+	comments bool
+	// words and symbols are the keywords starting with a letter and the
+	// other ones.
+	words, symbols Keywords
+}
+
+// ScannerOption configures a Scanner.
+type ScannerOption func(s *Scanner)
+
+// WithKeywords replaces the keywords of the scanner, DefaultKeywords and
+// OperatorAliases, by the union of tables.
+func WithKeywords(tables ...Keywords) ScannerOption {
+	return func(s *Scanner) {
+		s.setKeywords(tables...)
+	}
+}
+
+// WithComments keeps the comments as TokenComment tokens, e.g. for a
+// formatter. The Parser skips them.
+func WithComments() ScannerOption {
+	return func(s *Scanner) {
+		s.comments = true
+	}
+}
+
+func NewScanner(src string, opts ...ScannerOption) *Scanner {
+	runes := []rune(src)
+	offsets := make([]int, 0, len(runes)+1)
+	for i := range src {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(src))
+
+	s := &Scanner{
+		src:     runes,
+		offsets: offsets,
+		line:    1,
+	}
+	s.setKeywords(DefaultKeywords, OperatorAliases)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func NewScanner(src string) *Scanner {
-	return &Scanner{
-		src:  []rune(src),
-		line: 1,
+func (s *Scanner) setKeywords(tables ...Keywords) {
+	s.words, s.symbols = Keywords{}, Keywords{}
+	for _, table := range tables {
+		for lexeme, typ := range table {
+			if r := []rune(lexeme); len(r) > 0 && (unicode.IsLetter(r[0]) || r[0] == '_') {
+				s.words[lexeme] = typ
+			} else {
+				s.symbols[lexeme] = typ
+			}
+		}
 	}
 }
 
+// ScanTokens scans the whole source. Invalid characters are reported and
+// skipped, so all of them are returned at once as an ErrorList, along with
+// the valid tokens.
 func (s *Scanner) ScanTokens() ([]*Token, error) {
+	if err := LimitSourceLength.check(len(s.src), s.Limits.MaxSourceLength); err != nil {
+		return nil, err
+	}
+
 	for !s.isAtEnd() {
 		// We are at the beginning of the next lexeme.
 		s.start = s.current
+		s.startLine, s.startColumn = s.line, s.current-s.lineStart+1
 		if err := s.scanToken(); err != nil {
+			s.errors = append(s.errors, err.(*Error))
+		}
+		if err := LimitTokens.check(len(s.tokens), s.Limits.MaxTokens); err != nil {
 			return nil, err
 		}
 	}
 
-	s.tokens = append(s.tokens, NewToken(TokenEOF, "", nil, s.line))
-	return s.tokens, nil
+	s.start = s.current
+	s.startLine, s.startColumn = s.line, s.current-s.lineStart+1
+	s.addToken(TokenEOF, nil)
+	return s.tokens, s.errors.Err()
 }
 
 //revive:disable:cyclomatic
 func (s *Scanner) scanToken() error {
+	if typ, ok := s.matchSymbol(); ok {
+		s.addToken(typ, nil)
+		return nil
+	}
+
 	c := s.advance()
 	switch c {
 	case '-':
 		s.addToken(TokenMinus, nil)
+	case '+':
+		s.addToken(TokenPlus, nil)
+	case '*':
+		s.addToken(TokenStar, nil)
+	case '%':
+		s.addToken(TokenPercent, nil)
 	case '(':
 		s.addToken(TokenLeftParen, nil)
 	case ')':
@@ -58,7 +181,12 @@ func (s *Scanner) scanToken() error {
 	case ',':
 		s.addToken(TokenComma, nil)
 	case '.':
+		if isDigit(s.peek()) {
+			return s.number()
+		}
 		s.addToken(TokenDot, nil)
+	case ':':
+		s.addToken(TokenColon, nil)
 	case '!':
 		if s.match('=') {
 			s.addToken(TokenBangEqual, nil)
@@ -67,7 +195,7 @@ func (s *Scanner) scanToken() error {
 		}
 	case '=':
 		if !s.match('=') {
-			return ScanError(report(s.line, "", "unexpected character"+string(c)))
+			return s.error("unexpected character " + string(c))
 		}
 		s.addToken(TokenEqualEqual, nil)
 	case '<':
@@ -76,36 +204,51 @@ func (s *Scanner) scanToken() error {
 		} else {
 			s.addToken(TokenLess, nil)
 		}
+	case '?':
+		if s.match('?') {
+			s.addToken(TokenQuestionQuestion, nil)
+		} else if s.peek() == '.' && !isDigit(s.peekNext()) {
+			// like JavaScript, cond?.5:1 is a conditional
+			s.advance()
+			s.addToken(TokenQuestionDot, nil)
+		} else {
+			s.addToken(TokenQuestion, nil)
+		}
 	case '>':
 		if s.match('=') {
 			s.addToken(TokenGreaterEqual, nil)
 		} else {
 			s.addToken(TokenGreater, nil)
 		}
-	// case '/':
-	// 	if s.match('/') {
-	// 		// A comment goes until the end of the line.
-	// 		for s.peek() != '\n' && !s.isAtEnd() {
-	// 			s.advance()
-	// 		}
-	// 	} else {
-	// 		s.addToken(SLASH, nil)
-	// 	}
+	case '/':
+		if s.match('/') {
+			s.lineComment()
+		} else if s.match('*') {
+			if err := s.blockComment(); err != nil {
+				return err
+			}
+		} else {
+			s.addToken(TokenSlash, nil)
+		}
 	case ' ', '\r', '\t':
 		// Ignore whitespace.
 	case '\n':
-		s.line++
-	case '"':
-		if err := s.string(); err != nil {
+		s.newline()
+	case '"', '\'':
+		if err := s.string(c); err != nil {
+			return err
+		}
+	case '`':
+		if err := s.rawString(); err != nil {
 			return err
 		}
 	default:
-		if unicode.IsDigit(c) {
-			s.number()
+		if isDigit(c) {
+			return s.number()
 		} else if unicode.IsLetter(c) {
 			s.identifier()
 		} else {
-			return ScanError(report(s.line, "", "unexpected character "+string(c)))
+			return s.error("unexpected character " + string(c))
 		}
 	}
 
@@ -116,7 +259,41 @@ func (s *Scanner) scanToken() error {
 
 func (s *Scanner) addToken(t TokenType, literal interface{}) {
 	text := string(s.src[s.start:s.current])
-	s.tokens = append(s.tokens, NewToken(t, text, literal, s.line))
+	token := NewToken(t, text, literal, s.startLine)
+	token.column = s.startColumn
+	token.offset = s.offsets[s.start]
+	s.tokens = append(s.tokens, token)
+}
+
+// newline is called after consuming a '\n'.
+func (s *Scanner) newline() {
+	s.line++
+	s.lineStart = s.current
+}
+
+// error returns an error located at the current lexeme.
+func (s *Scanner) error(msg string) error {
+	return &Error{
+		Kind:   KindScan,
+		Msg:    msg,
+		Start:  s.offsets[s.start],
+		End:    s.offsets[s.current],
+		Line:   s.startLine,
+		Column: s.startColumn,
+	}
+}
+
+// errorAt returns an error located at src[start:s.current], which must be
+// on the current line.
+func (s *Scanner) errorAt(start int, msg string) *Error {
+	return &Error{
+		Kind:   KindScan,
+		Msg:    msg,
+		Start:  s.offsets[start],
+		End:    s.offsets[s.current],
+		Line:   s.line,
+		Column: start - s.lineStart + 1,
+	}
 }
 
 func (s *Scanner) advance() rune {
@@ -156,46 +333,198 @@ func (s *Scanner) isAtEnd() bool {
 	return s.current >= len(s.src)
 }
 
-func (s *Scanner) string() error {
-	for s.peek() != '"' && !s.isAtEnd() {
-		// TODO: support multi-line strings?
-		if s.peek() == '\n' {
-			s.line++
-		}
+// lineComment scans a comment from "//" to the end of the line.
+func (s *Scanner) lineComment() {
+	for s.peek() != '\n' && !s.isAtEnd() {
 		s.advance()
 	}
+	s.addComment()
+}
+
+// blockComment scans a comment from "/*" to "*/", which may span several
+// lines. Block comments do not nest.
+func (s *Scanner) blockComment() error {
+	for !(s.peek() == '*' && s.peekNext() == '/') {
+		if s.isAtEnd() {
+			return s.error("unterminated comment")
+		}
+		if s.advance() == '\n' {
+			s.newline()
+		}
+	}
+
+	// The closing */.
+	s.advance()
+	s.advance()
+	s.addComment()
+	return nil
+}
+
+func (s *Scanner) addComment() {
+	if s.comments {
+		s.addToken(TokenComment, nil)
+	}
+}
+
+// string scans a string quoted by quote, which may span several lines.
+// Invalid escapes are reported but do not end the string.
+func (s *Scanner) string(quote rune) error {
+	var value strings.Builder
+	for s.peek() != quote && !s.isAtEnd() {
+		c := s.advance()
+		switch c {
+		case '\n':
+			s.newline()
+		case '\\':
+			r, err := s.escape(quote)
+			if err != nil {
+				s.errors = append(s.errors, err)
+				continue
+			}
+			c = r
+		}
+		value.WriteRune(c)
+	}
+
+	if s.isAtEnd() {
+		return s.error("unterminated string")
+	}
+
+	// The closing quote.
+	s.advance()
+
+	s.addToken(TokenString, value.String())
+
+	return nil
+}
+
+// escape scans an escape sequence after the backslash.
+func (s *Scanner) escape(quote rune) (rune, *Error) {
+	start := s.current - 1
+	if s.isAtEnd() {
+		return 0, s.errorAt(start, "unterminated escape sequence")
+	}
+
+	c := s.advance()
+	switch c {
+	case quote, '\\':
+		return c, nil
+	case 'n':
+		return '\n', nil
+	case 't':
+		return '\t', nil
+	case 'r':
+		return '\r', nil
+	case 'u':
+		for i := 0; i < 4; i++ {
+			if !isHexDigit(s.peek()) {
+				return 0, s.errorAt(start, "invalid unicode escape "+string(s.src[start:s.current]))
+			}
+			s.advance()
+		}
+		n, _ := strconv.ParseUint(string(s.src[s.current-4:s.current]), 16, 32)
+		if utf16.IsSurrogate(rune(n)) {
+			// a lone surrogate is not a character
+			return 0, s.errorAt(start, "invalid unicode escape "+string(s.src[start:s.current]))
+		}
+		return rune(n), nil
+	case '\n':
+		err := s.errorAt(start, "unterminated escape sequence")
+		s.newline()
+		return 0, err
+	}
+
+	return 0, s.errorAt(start, "invalid escape sequence "+string(s.src[start:s.current]))
+}
+
+// rawString scans a string quoted by backticks, in which backslashes have
+// no special meaning.
+func (s *Scanner) rawString() error {
+	for s.peek() != '`' && !s.isAtEnd() {
+		if s.advance() == '\n' {
+			s.newline()
+		}
+	}
 
 	if s.isAtEnd() {
-		return ScanError(report(s.line, "", "Unterminated string."))
+		return s.error("unterminated string")
 	}
 
-	// The closing ".
+	// The closing `.
 	s.advance()
 
-	// Trim the surrounding quotes.
-	value := s.src[s.start+1 : s.current-1]
+	// Trim the surrounding backticks.
+	value := string(s.src[s.start+1 : s.current-1])
 	s.addToken(TokenString, value)
 
 	return nil
 }
 
-func (s *Scanner) number() {
-	for unicode.IsDigit(s.peek()) {
+// number scans an integer, which becomes an int64, or a float, which
+// becomes a float64. Digits may be separated by underscores, as in Go.
+func (s *Scanner) number() error {
+	first := s.src[s.start]
+	if first == '0' && (s.peek() == 'x' || s.peek() == 'X') {
 		s.advance()
+		if !isHexDigit(s.peek()) {
+			return s.error("invalid hexadecimal number " + string(s.src[s.start:s.current]))
+		}
+		s.digits(isHexDigit)
+		return s.integer(string(s.src[s.start+2:s.current]), 16)
 	}
 
+	isFloat := first == '.'
+	s.digits(isDigit)
+
 	// Look for a fractional part.
-	if s.peek() == '.' && unicode.IsDigit(s.peekNext()) {
+	if !isFloat && s.peek() == '.' && isDigit(s.peekNext()) {
 		// Consume the "."
 		s.advance()
+		s.digits(isDigit)
+		isFloat = true
 	}
 
-	for unicode.IsDigit(s.peek()) {
+	// Look for an exponent.
+	if s.peek() == 'e' || s.peek() == 'E' {
 		s.advance()
+		if s.peek() == '+' || s.peek() == '-' {
+			s.advance()
+		}
+		if !isDigit(s.peek()) {
+			return s.error("invalid exponent in number " + string(s.src[s.start:s.current]))
+		}
+		s.digits(isDigit)
+		isFloat = true
+	}
+
+	text := string(s.src[s.start:s.current])
+	if !isFloat {
+		return s.integer(text, 10)
 	}
 
-	val, _ := strconv.ParseFloat(string(s.src[s.start:s.current]), 64)
+	val, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return s.error("number out of range " + text)
+	}
 	s.addToken(TokenNumber, val)
+	return nil
+}
+
+// digits consumes the digits accepted by isDigit, with single underscores
+// between them.
+func (s *Scanner) digits(isDigit func(rune) bool) {
+	for isDigit(s.peek()) || s.peek() == '_' && isDigit(s.peekNext()) {
+		s.advance()
+	}
+}
+
+func (s *Scanner) integer(digits string, base int) error {
+	val, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+	if err != nil {
+		return s.error("number out of range " + string(s.src[s.start:s.current]))
+	}
+	s.addToken(TokenNumber, val)
+	return nil
 }
 
 func (s *Scanner) identifier() {
@@ -204,13 +533,87 @@ func (s *Scanner) identifier() {
 	}
 
 	text := string(s.src[s.start:s.current])
-	if typ, ok := keywords[text]; ok {
+	if text == "not" && s.matchWord("in") {
+		s.addToken(TokenNotIn, nil)
+		return
+	}
+	if typ, ok := s.words[text]; ok {
 		s.addToken(typ, nil)
 	} else {
 		s.addToken(TokenIdentifier, nil)
 	}
 }
 
+// matchSymbol consumes the longest symbol keyword at the current position,
+// unless it is the prefix of a longer built-in operator.
+func (s *Scanner) matchSymbol() (TokenType, bool) {
+	var typ TokenType
+	length := 0
+	for lexeme, t := range s.symbols {
+		if n := len([]rune(lexeme)); n > length && s.lookingAt(lexeme) {
+			typ, length = t, n
+		}
+	}
+	if length == 0 {
+		return 0, false
+	}
+
+	for _, op := range operators {
+		if len(op) > length && s.lookingAt(op) {
+			return 0, false
+		}
+	}
+
+	s.current += length
+	return typ, true
+}
+
+// lookingAt reports whether the source at the current position starts with
+// lexeme.
+func (s *Scanner) lookingAt(lexeme string) bool {
+	i := s.current
+	for _, c := range lexeme {
+		if i >= len(s.src) || s.src[i] != c {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// matchWord consumes the whitespace and the following word if the word is
+// expected, so that two-word operators like "not in" become a single token.
+func (s *Scanner) matchWord(expected string) bool {
+	current, line, lineStart := s.current, s.line, s.lineStart
+	for s.peek() == ' ' || s.peek() == '\r' || s.peek() == '\t' || s.peek() == '\n' {
+		if s.advance() == '\n' {
+			s.newline()
+		}
+	}
+
+	for _, c := range expected {
+		if !s.match(c) {
+			s.current, s.line, s.lineStart = current, line, lineStart
+			return false
+		}
+	}
+
+	if isIdentifier(s.peek()) {
+		s.current, s.line, s.lineStart = current, line, lineStart
+		return false
+	}
+
+	return true
+}
+
+func isDigit(c rune) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c rune) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
 func isIdentifier(c rune) bool {
 	if isAlphaNumeric(c) {
 		return true
@@ -221,9 +624,3 @@ func isIdentifier(c rune) bool {
 func isAlphaNumeric(c rune) bool {
 	return unicode.IsDigit(c) || unicode.IsLetter(c)
 }
-
-type ScanError string
-
-func (p ScanError) Error() string {
-	return string(p)
-}
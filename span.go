@@ -0,0 +1,31 @@
+package expr
+
+// Span is the position of an expression in its source.
+type Span struct {
+	// Start and End are the byte offsets of the first character and just
+	// after the last character of the expression.
+	Start, End int
+	// Line and Column are the 1-based position of Start, with Column counted
+	// in characters.
+	Line, Column int
+}
+
+// span returns the span of the token.
+func (t *Token) span() Span {
+	return Span{Start: t.offset, End: t.end(), Line: t.line, Column: t.column}
+}
+
+// newSpan returns the span from token from to token to, inclusive.
+func newSpan(from, to *Token) Span {
+	s := from.span()
+	if to.end() > s.End {
+		s.End = to.end()
+	}
+	return s
+}
+
+// IsValid reports whether the span was set by the Parser; the expressions
+// created by the NewExpr functions have no position.
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
@@ -14,16 +14,24 @@ const (
 	TokenRightBracket                  // ]
 	TokenComma                         // ,
 	TokenDot                           // .
+	TokenPlus                          // +
+	TokenStar                          // *
+	TokenSlash                         // /
+	TokenPercent                       // %
+	TokenColon                         // :
 
 	// One or two character tokens.
-	TokenMinus        // -
-	TokenBang         // !
-	TokenBangEqual    // !=
-	TokenEqualEqual   // ==
-	TokenGreater      // >
-	TokenGreaterEqual // >=
-	TokenLess         // <
-	TokenLessEqual    // <=
+	TokenMinus            // -
+	TokenBang             // !
+	TokenBangEqual        // !=
+	TokenEqualEqual       // ==
+	TokenGreater          // >
+	TokenGreaterEqual     // >=
+	TokenLess             // <
+	TokenLessEqual        // <=
+	TokenQuestionQuestion // ??
+	TokenQuestionDot      // ?.
+	TokenQuestion         // ?
 
 	// Literals.
 	TokenIdentifier // a
@@ -31,11 +39,21 @@ const (
 	TokenNumber     // 123
 
 	// Keywords.
-	TokenAnd   // and
-	TokenOr    // or
-	TokenNil   // nil
-	TokenTrue  // true
-	TokenFalse // false
+	TokenAnd         // and
+	TokenOr          // or
+	TokenNil         // nil
+	TokenTrue        // true
+	TokenFalse       // false
+	TokenIn          // in
+	TokenNotIn       // not in
+	TokenContains    // contains
+	TokenContainsAny // contains_any
+	TokenContainsAll // contains_all
+	TokenSubsetOf    // subset_of
+	TokenIntersects  // intersects
+
+	// TokenComment is only produced by a Scanner created WithComments.
+	TokenComment // // comment
 
 	TokenEOF
 )
@@ -45,6 +63,11 @@ type Token struct {
 	lexeme  string
 	literal interface{}
 	line    int
+	// column is the 1-based column of the first character in the line,
+	// counted in characters.
+	column int
+	// offset is the byte offset of the lexeme in the source.
+	offset int
 }
 
 func NewToken(typ TokenType, lexeme string, literal interface{}, line int) *Token {
@@ -56,6 +79,38 @@ func NewToken(typ TokenType, lexeme string, literal interface{}, line int) *Toke
 	}
 }
 
+func (t *Token) Type() TokenType {
+	return t.typ
+}
+
+func (t *Token) Lexeme() string {
+	return t.lexeme
+}
+
+// Literal returns the value of a string or number literal.
+func (t *Token) Literal() interface{} {
+	return t.literal
+}
+
+func (t *Token) Line() int {
+	return t.line
+}
+
+// Column returns the 1-based column of the token, counted in characters.
+func (t *Token) Column() int {
+	return t.column
+}
+
+// Offset returns the byte offset of the token in the source.
+func (t *Token) Offset() int {
+	return t.offset
+}
+
+// end returns the byte offset just after the lexeme.
+func (t *Token) end() int {
+	return t.offset + len(t.lexeme)
+}
+
 func (t Token) string() string {
 	return fmt.Sprintf("%d %s %v", t.typ, t.lexeme, t.literal)
 }
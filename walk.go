@@ -0,0 +1,84 @@
+package expr
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the children of the
+// node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(expr Expr) (w Visitor)
+}
+
+// Walk traverses an expression in depth-first order, like ast.Walk: it
+// starts by calling v.Visit(expr); if the visitor w returned is not nil,
+// Walk is invoked recursively with w for each of the non-nil children of
+// expr, followed by a call of w.Visit(nil).
+func Walk(v Visitor, expr Expr) {
+	if v = v.Visit(expr); v == nil {
+		return
+	}
+
+	for _, child := range expr.children() {
+		if child != nil {
+			Walk(v, child)
+		}
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Expr) bool
+
+func (f inspector) Visit(expr Expr) Visitor {
+	if f(expr) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an expression in depth-first order: it starts by
+// calling f(expr); if f returns true, Inspect invokes f recursively for each
+// of the non-nil children of expr, followed by a call of f(nil).
+//
+// For example, listing the entity codes of a rule:
+//
+//	expr.Inspect(e, func(e expr.Expr) bool {
+//		if call, ok := e.(*expr.ExprCall); ok && len(call.Arguments()) == 1 {
+//			if code, ok := call.Arguments()[0].(*expr.ExprLiteral); ok {
+//				codes = append(codes, code.Value().(string))
+//			}
+//		}
+//		return true
+//	})
+func Inspect(expr Expr, f func(Expr) bool) {
+	Walk(inspector(f), expr)
+}
+
+// Rewrite returns expr with each node replaced by f(node), in depth-first
+// order: the children of a node are rewritten before the node itself, and f
+// receives the node with its rewritten children. f returns its argument to
+// keep a node.
+//
+// expr is left untouched: the nodes whose children are replaced are copied,
+// and the unchanged subtrees are shared with the result.
+func Rewrite(expr Expr, f func(Expr) Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+
+	children := expr.children()
+	var rewritten []Expr
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		if r := Rewrite(child, f); r != child {
+			if rewritten == nil {
+				rewritten = append([]Expr(nil), children...)
+			}
+			rewritten[i] = r
+		}
+	}
+	if rewritten != nil {
+		expr = expr.withChildren(rewritten)
+	}
+
+	return f(expr)
+}
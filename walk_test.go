@@ -0,0 +1,97 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_inspect(t *testing.T) {
+	e, err := toExpr(`ner_entities("肤质") == ["干性"] and (ner_entities("功效") contains "补水" or user.tags[1:] == [])`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+	var nodes, nils int
+	Inspect(e, func(e Expr) bool {
+		if e == nil {
+			nils++
+			return true
+		}
+		nodes++
+		if call, ok := e.(*ExprCall); ok {
+			callee, ok := call.Callee().(*ExprVariable)
+			if !ok || callee.Name().Lexeme() != "ner_entities" || len(call.Arguments()) != 1 {
+				return true
+			}
+			if code, ok := call.Arguments()[0].(*ExprLiteral); ok {
+				codes = append(codes, code.Value().(string))
+			}
+			return false
+		}
+		return true
+	})
+
+	if !reflect.DeepEqual(codes, []string{"肤质", "功效"}) {
+		t.Fatalf("unexpected codes %v", codes)
+	}
+	// the calls are visited without their children, the nil slice bound is
+	// skipped
+	if nodes != 16 || nils != 14 {
+		t.Fatalf("unexpected number of nodes %d, nils %d", nodes, nils)
+	}
+}
+
+func Test_rewrite(t *testing.T) {
+	src := `f(a, 1) and [a, b][0] == a ? a.x : -a`
+	e, err := toExpr(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printer := &AstPrinter{}
+	before := printer.Print(e)
+
+	replaced := Rewrite(e, func(e Expr) Expr {
+		if v, ok := e.(*ExprVariable); ok && v.Name().Lexeme() == "a" {
+			return NewExprLiteral(int64(2), reflect.Int64)
+		}
+		return e
+	})
+
+	if got := printer.Print(replaced); got != "(? (and (f 2 1) (== (index [] 2 b] 0) 2)) (. 2 x) (- 2))" {
+		t.Fatalf("unexpected rewritten expression %s", got)
+	}
+	if got := printer.Print(e); got != before {
+		t.Fatalf("original expression modified: %s", got)
+	}
+
+	same := Rewrite(e, func(e Expr) Expr { return e })
+	if same != e {
+		t.Fatal("want the same expression when nothing is replaced")
+	}
+
+	// constant folding
+	e, err = toExpr(`x > 1 + 2 * 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folded := Rewrite(e, func(e Expr) Expr {
+		b, ok := e.(*ExprBinary)
+		if !ok {
+			return e
+		}
+		l, lok := b.Left().(*ExprLiteral)
+		r, rok := b.Right().(*ExprLiteral)
+		if !lok || !rok || b.Operator().Type() == TokenGreater {
+			return e
+		}
+		v, err := NewInterpreter().Interpret(NewExprBinary(l, b.Operator(), r))
+		if err != nil {
+			return e
+		}
+		return NewExprLiteral(v, reflect.TypeOf(v).Kind())
+	})
+	if got := printer.Print(folded); got != "(> x 7)" {
+		t.Fatalf("unexpected folded expression %s", got)
+	}
+}